@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"math"
+	"testing"
+)
+
+// Minutes of synthetic telemetry the benchmarks load, an hour long endurance race
+const benchMinutes = 60
+
+// Writes minutes of 60 Hz FM8 telemetry, repeating a one minute cycle of
+// launching, running to top speed, braking to a stop and idling.
+// Returns the number of rows written.
+func writeSyntheticLog(out io.Writer, minutes int) (int, error) {
+	format := packetFormats["fm8"]
+	w := csv.NewWriter(out)
+	if err := w.Write(append(format.Headers(), formatColumn)); err != nil {
+		return 0, err
+	}
+
+	p := Packet{Format: format, Values: make([]float64, len(format.named))}
+	set := func(name string, v float64) {
+		p.Values[format.index[name]] = v
+	}
+	set("IsRaceOn", 1)
+	set("EngineMaxRpm", 8000)
+	set("EngineIdleRpm", 800)
+	set("CarOrdinal", 1234)
+	set("CarClass", 5)
+	set("CarPerformanceIndex", 800)
+	set("DrivetrainType", 1)
+	set("NumCylinders", 8)
+	set("TrackOrdinal", 500)
+
+	rows := minutes * 60 * 60
+	speed, distance := 0.0, 0.0
+	for i := 0; i < rows; i++ {
+		t := float64(i%3600) / 60 // seconds into the cycle
+		switch {
+		case t < 30: // accelerating towards about 90 m/s (200 mph)
+			speed = 90 * (1 - math.Exp(-t/8))
+		case t < 42: // braking
+			speed = math.Max(0, speed-9.0/60)
+		default: // stopped
+			speed = 0
+		}
+		distance += speed / 60
+		rpm := 800 + math.Mod(speed*200, 7200)
+
+		set("TimestampMS", float64(i)*1000/60)
+		set("CurrentEngineRpm", rpm)
+		set("Speed", speed)
+		set("VelocityZ", speed)
+		set("PositionZ", distance)
+		set("DistanceTraveled", distance)
+		set("Power", 300000*math.Sin(math.Pi*rpm/8000))
+		set("Torque", 500*math.Sin(math.Pi*rpm/9000))
+		set("Boost", 10*rpm/8000)
+		set("Gear", math.Floor(speed*200/7200)+1)
+		set("CurrentRaceTime", float64(i)/60)
+		if err := w.Write(append(p.Row(), format.Name)); err != nil {
+			return 0, err
+		}
+	}
+	w.Flush()
+	return rows, w.Error()
+}
+
+// Returns a synthetic log of benchMinutes as CSV
+func syntheticLog(b *testing.B) []byte {
+	var buf bytes.Buffer
+	if _, err := writeSyntheticLog(&buf, benchMinutes); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Reading the whole file into memory at once, as logs used to be, to compare against
+func BenchmarkReadAll(b *testing.B) {
+	data := syntheticLog(b)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := csv.NewReader(bytes.NewReader(data)).ReadAll(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadSession(b *testing.B) {
+	data := syntheticLog(b)
+	rows := bytes.Count(data, []byte{'\n'}) - 1
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := readSession("synthetic.csv", bytes.NewReader(data), rows, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCalculate(b *testing.B) {
+	data := syntheticLog(b)
+	session, err := readSession("synthetic.csv", bytes.NewReader(data), 0, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	intervals, err := defaultIntervals()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		calculate(session, intervalTiming{Config: intervals})
+		calcDragTimes(session)
+	}
+}
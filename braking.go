@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	gravity      = 9.80665 // m/s², to convert acceleration to g
+	metersToFeet = 3.28084
+	lockedSlip   = 1.0 // A TireSlipRatio beyond this means the tire has lost grip, so under braking the wheel is locked
+)
+
+// Slip ratio channels of each wheel, and the short name used in the report
+var wheelSlipChannels = []struct {
+	Name    string
+	Channel string
+}{
+	{"FL", "TireSlipRatioFrontLeft"},
+	{"FR", "TireSlipRatioFrontRight"},
+	{"RL", "TireSlipRatioRearLeft"},
+	{"RR", "TireSlipRatioRearRight"},
+}
+
+// How the car performed slowing through one braking interval (e.g. 60-0 mph)
+type brakingEvent struct {
+	Name         string   // Name of the interval
+	Stop         int      // Data point where the car reached the end speed, shared by intervals ending in the same stop
+	StartTime    float64  // Seconds since the start of the log
+	Time         float64  // Seconds
+	Uncertainty  float64  // Seconds
+	Distance     float64  // Meters
+	PeakDecel    float64  // g
+	MeanDecel    float64  // g
+	LockedTime   float64  // Seconds with at least one wheel locked
+	LockedWheels []string // Wheels that locked, e.g. "FL"
+}
+
+// Finds every time the car slowed through each braking interval (From > To) and
+// works out how far it took, how hard the car decelerated (from AccelerationZ)
+// and whether any wheels locked (from TireSlipRatio).
+func calcBraking(session *Session, intervals []speedInterval, smoothing int) []brakingEvent {
+	check(session.Require("TimestampMS", "Speed"))
+	t := session.Float("TimestampMS")
+	seconds := make([]float64, len(t))
+	for i, v := range t {
+		seconds[i] = (v - t[0]) / 1000
+	}
+	speeds := smoothSpeeds(session.Float("Speed"), smoothing)
+	accel := session.Float("AccelerationZ")
+
+	var events []brakingEvent
+	for _, interval := range intervals {
+		if interval.From < interval.To {
+			continue
+		}
+		unit := speedUnits[interval.Unit]
+		s := make([]float64, len(speeds))
+		for i, v := range speeds {
+			s[i] = v * unit
+		}
+		startLevel := math.Max(interval.From, standstillLevel)
+		endLevel := math.Max(interval.To, standstillLevel)
+
+		for _, crossing := range findCrossings(startLevel, endLevel, s) {
+			startTime, startError := crossingTime(startLevel, crossing.Start, seconds, s)
+			endTime, endError := crossingTime(endLevel, crossing.End, seconds, s)
+			event := brakingEvent{
+				Name:        interval.Name,
+				Stop:        crossing.End,
+				StartTime:   startTime,
+				Time:        endTime - startTime,
+				Uncertainty: math.Sqrt(startError*startError + endError*endError),
+			}
+
+			// Integrate speed over time, starting and ending exactly at the crossings
+			lastTime, lastSpeed := startTime, startLevel/unit
+			for i := crossing.Start; i < crossing.End; i++ {
+				event.Distance += (seconds[i] - lastTime) * (lastSpeed + speeds[i]) / 2
+				lastTime, lastSpeed = seconds[i], speeds[i]
+			}
+			event.Distance += (endTime - lastTime) * (lastSpeed + endLevel/unit) / 2
+
+			// AccelerationZ is forward, so braking is negative
+			if accel != nil {
+				total := 0.0
+				for i := crossing.Start; i < crossing.End; i++ {
+					decel := -accel[i] / gravity
+					event.PeakDecel = math.Max(event.PeakDecel, decel)
+					total += decel
+				}
+				event.MeanDecel = total / float64(crossing.End-crossing.Start)
+			}
+
+			event.LockedTime, event.LockedWheels = wheelLock(session, crossing.Start, crossing.End, seconds)
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(a, b int) bool {
+		return events[a].Stop < events[b].Stop
+	})
+	return events
+}
+
+// Returns how long at least one wheel was locked between data points start and
+// end, and which wheels locked. Logs without slip ratios never lock.
+func wheelLock(session *Session, start int, end int, seconds []float64) (float64, []string) {
+	var wheels []string
+	lockedTime := 0.0
+	for i := start; i < end; i++ {
+		locked := false
+		for _, wheel := range wheelSlipChannels {
+			slip := session.Float(wheel.Channel)
+			if slip == nil || math.Abs(slip[i]) <= lockedSlip {
+				continue
+			}
+			locked = true
+			if !containsString(wheels, wheel.Name) {
+				wheels = append(wheels, wheel.Name)
+			}
+		}
+		if locked && i+1 < len(seconds) {
+			lockedTime += seconds[i+1] - seconds[i]
+		}
+	}
+	return lockedTime, wheels
+}
+
+// Prints the braking report for every stop in the session, using the braking
+// intervals (e.g. 60-0 and 100-0 mph) for the car's class
+func printBraking(session *Session, timing intervalTiming) {
+	first := session.Frame(0)
+	intervals := timing.Config.forClass(session.Format, session.Format.ClassLetter(first.Int("CarClass")))
+	events := calcBraking(session, intervals, timing.Smoothing)
+	if len(events) == 0 {
+		fmt.Println("No braking found in log.")
+		return
+	}
+
+	lastStop := -1
+	for _, event := range events {
+		if event.Stop != lastStop {
+			fmt.Printf("\nStop at %.2f seconds:\n", session.Frame(event.Stop).Float("TimestampMS")/1000-first.Float("TimestampMS")/1000)
+			lastStop = event.Stop
+		}
+		lockup := "no lock-up"
+		if len(event.LockedWheels) > 0 {
+			lockup = fmt.Sprintf("%s locked for %.2f s", strings.Join(event.LockedWheels, ", "), event.LockedTime)
+		}
+		fmt.Printf("  %s: %.3f ± %.4f s, %.1f ft (%.1f m), peak %.2f g, mean %.2f g, %s\n", event.Name,
+			event.Time, event.Uncertainty, event.Distance*metersToFeet, event.Distance, event.PeakDecel, event.MeanDecel, lockup)
+	}
+}
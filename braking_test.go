@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestCalcBraking(t *testing.T) {
+	// 30 m/s braking at a steady 10 m/s² to a stop, at 60 data points a second,
+	// with the front left wheel locked for the first half second
+	const decel = 10.0
+	columns := []string{"TimestampMS", "Speed", "AccelerationZ", "TireSlipRatioFrontLeft", "TireSlipRatioFrontRight"}
+	session := buildSession(columns, 240, func(i int) []float64 {
+		seconds := float64(i) / 60
+		speed := math.Max(0, 30-decel*seconds)
+		accel, slip := -decel, 0.0
+		if speed == 0 {
+			accel = 0
+		}
+		if seconds < 0.5 {
+			slip = -1.5
+		}
+		return []float64{seconds * 1000, speed, accel, slip, 0.2}
+	})
+	intervals, err := normalizeIntervals([]speedInterval{{From: 60, To: 0}, {From: 0, To: 60}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := calcBraking(session, intervals, 0)
+	if len(events) != 1 {
+		t.Fatalf("calcBraking found %d braking events, want 1", len(events))
+	}
+	event := events[0]
+	start := 60 / speedUnits["mph"]
+	end := standstillLevel / speedUnits["mph"]
+	wantTime := (start - end) / decel
+	wantDistance := (start*start - end*end) / (2 * decel)
+	if event.Name != "60-0 mph" || math.Abs(event.Time-wantTime) > 0.001 || math.Abs(event.Distance-wantDistance) > 0.01 {
+		t.Errorf("calcBraking = %s in %.4f s over %.3f m, want 60-0 mph in %.4f s over %.3f m",
+			event.Name, event.Time, event.Distance, wantTime, wantDistance)
+	}
+	if math.Abs(event.PeakDecel-decel/gravity) > 1e-9 {
+		t.Errorf("calcBraking peak deceleration %.4f g, want %.4f g", event.PeakDecel, decel/gravity)
+	}
+	// 60 mph is crossed at 0.318 s, so data points 20 to 29 (0.333 to 0.483 s) are locked
+	if !reflect.DeepEqual(event.LockedWheels, []string{"FL"}) || math.Abs(event.LockedTime-10.0/60) > 1e-9 {
+		t.Errorf("calcBraking locked %v for %.4f s, want [FL] for %.4f s", event.LockedWheels, event.LockedTime, 10.0/60)
+	}
+}
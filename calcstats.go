@@ -1,66 +1,38 @@
 package main
 
 import (
-	"encoding/csv"
 	"errors"
 	"fmt"
 	"log"
 	"math"
-	"os"
 	"sort"
 	"strconv"
 )
 
-func calcstats(csvFile string) []string {
-	rows := readLog(csvFile)
-	var output []string
-	// check data is received before doing anything, else will crash due to no data in the csv file
-	if len(rows) > 1 {
-		fmt.Printf("Successfully processed %d data points!\n", len(rows))
-		rows, output = calculate(rows)
+// Calculates the stat line from a session, timing the given speed intervals
+func calcstats(session *Session, timing intervalTiming) *statResults {
+	var output *statResults
+	// check data is received before doing anything, else will crash due to no data in the session
+	if session.Len() > 0 {
+		fmt.Printf("Successfully processed %d data points!\n", session.Len())
+		output = calculate(session, timing)
 	}
 	return output
 }
 
-func readLog(name string) [][]string {
-
-	f, err := os.Open(name)
+// Loads a CSV log recorded with the given packet format (nil to work it out from
+// the log), exiting if it can't be read or is missing required columns
+func readLog(name string, format *PacketFormat) *Session {
+	s, err := loadSession(name, format)
 	// Usually we would return the error to the caller and handle
 	// all errors in function `main()`. However, this is just a
 	// small command-line tool, and so we use `log.Fatal()`
 	// instead, in order to write the error message to the
 	// terminal and exit immediately.
 	if err != nil {
-		log.Fatalf("Cannot open '%s': %s\n", name, err.Error())
+		log.Fatalln(err)
 	}
-
-	// After this point, the file has been successfully opened,
-	// and we want to ensure that it gets closed when no longer
-	// needed, so we add a deferred call to `f.Close()`.
-	defer f.Close()
-
-	// To read in the CSV data, we create a new CSV reader that
-	// reads from the input file.
-	//
-	// The CSV reader is aware of the CSV data format. It
-	// separates the input stream into rows and columns,
-	// and returns a slice of slices of strings.
-	r := csv.NewReader(f)
-
-	// We can even adjust the reader to recognize a semicolon,
-	// rather than a comma, as the column separator.
-	r.Comma = ','
-
-	// Read the whole file at once. (We don't expect large files.)
-	rows, err := r.ReadAll()
-
-	// Again, we check for any error,
-	if err != nil {
-		log.Fatalln("Cannot read CSV data:", err.Error())
-	}
-
-	// and finally we can return the rows.
-	return rows
+	return s
 }
 
 func check(e error) {
@@ -70,240 +42,44 @@ func check(e error) {
 }
 
 // Calculate Drag Race Statistics:
-// Returns an array of times (as strings) for 1/8mi, 1/4mi, 1/2mi and 1mi, along with an array of corresponding speeds for each
-func calcDragTimes(csvFile string) (times []string, speeds []string) {
-	rows := readLog(csvFile)
-	// check data is received before doing anything, else will crash due to no data in the csv file
-	if len(rows) < 1 {
-		log.Fatalf("CSV File is empty!")
-	}
-
-	// Find row numbers based on column header names (row 0)
-	timeRow := 0
-	speedRow := 0
-
-	for k, v := range rows[0] {
-		if v == "TimestampMS" {
-			timeRow = k
-		} else if v == "Speed" {
-			speedRow = k
-		}
-	}
-
-	var t []float64  // array of timestamp values
-	var s []float64  // array of speed values
-	var as []float64 // array of running average speed values
-	var d []float64  // array of calculated distance traveled values
-
-	for i := range rows {
-		if i == 0 { // skip first row (header/column names)
-			continue
-		}
-
-		// Add ALL speed value from row to array
-		speed, err := strconv.ParseFloat(rows[i][speedRow], 32)
-		check(err)
-		s = append(s, (speed)) // kept as meters/sec
-	}
-
-	startIndex := 0
-	for s[startIndex] < 0.05 { // While current speed is less than 0.05 m/s,
-		startIndex++ // Increase until we find the index at which the car actually starts moving
-	}
-
-	s = nil // Reset speed values
-	for i := range rows {
-		if i <= startIndex { // Ignore all values before the car starts moving
-			continue
-		}
-
-		// Add only timestamps after car starts moving from row to array
-		time, err := strconv.ParseFloat(rows[i][timeRow], 32)
-		check(err)
-		t = append(t, time)
-
-		// Add only speed values after car starts moving from row to array
-		speed, err := strconv.ParseFloat(rows[i][speedRow], 32)
-		check(err)
-		s = append(s, (speed)) // kept as meters/sec
-	}
-
-	sum := 0.0         // Running sum of speed values
-	avg := 0.0         // Running average of speed values
-	for i := range s { // Populate Average Speed Values
-		sum = sum + s[i]
-		avg = sum / float64((i + 1))
-		as = append(as, avg)
-	}
-
-	currTime := 0.0     // Running time the car has been moving
-	dist := 0.0         // Running distance traveled in miles
-	for i := range as { // Populate Distance Values
-		currTime = (t[i] - t[0]) / 1000        // Current time since motion started set in seconds
-		dist = as[i] * currTime * 0.0006213712 // Distance = avgSpeed * Time, converted from meters to miles
-		d = append(d, dist)
-	}
-
-	var dragTimes []string
-	var dragSpeeds []string
-
-	//Find 1/8 Mile Time + Speed
-	if d[len(d)-1] < 0.1251 { // Car didn't travel 1/8 mile
-		dragTimes = append(dragTimes, "Failed!")
-		dragSpeeds = append(dragSpeeds, "Failed!")
-	} else {
-		var dragTime string
-		var dragSpeed string
-		i := 0
-		for d[i] <= 0.1251 {
-			dragTime = strconv.FormatFloat((t[i]-t[0])/1000, 'f', 3, 32)
-			dragSpeed = strconv.FormatFloat(s[i]*2.237, 'f', 2, 32)
-			i++
-		}
-		dragTimes = append(dragTimes, dragTime)
-		dragSpeeds = append(dragSpeeds, dragSpeed)
-	}
-
-	//Find 1/4 Mile Time + Speed
-	if d[len(d)-1] < 0.2501 { // Car didn't travel 1/4 mile
-		dragTimes = append(dragTimes, "Failed!")
-		dragSpeeds = append(dragSpeeds, "Failed!")
-	} else {
-		var dragTime string
-		var dragSpeed string
-		i := 0
-		for d[i] <= 0.2501 {
-			dragTime = strconv.FormatFloat((t[i]-t[0])/1000, 'f', 3, 32)
-			dragSpeed = strconv.FormatFloat(s[i]*2.237, 'f', 2, 32)
-			i++
-		}
-		dragTimes = append(dragTimes, dragTime)
-		dragSpeeds = append(dragSpeeds, dragSpeed)
-	}
-
-	//Find 1/2 Mile Time + Speed
-	if d[len(d)-1] < 0.5001 { // Car didn't travel 1/2 mile
-		dragTimes = append(dragTimes, "Failed!")
-		dragSpeeds = append(dragSpeeds, "Failed!")
-	} else {
-		var dragTime string
-		var dragSpeed string
-		i := 0
-		for d[i] <= 0.5001 {
-			dragTime = strconv.FormatFloat((t[i]-t[0])/1000, 'f', 3, 32)
-			dragSpeed = strconv.FormatFloat(s[i]*2.237, 'f', 2, 32)
-			i++
-		}
-		dragTimes = append(dragTimes, dragTime)
-		dragSpeeds = append(dragSpeeds, dragSpeed)
-	}
-
-	//Find 1 Mile Time + Speed
-	if d[len(d)-1] < 1.0001 { // Car didn't travel 1 mile
-		dragTimes = append(dragTimes, "Failed!")
-		dragSpeeds = append(dragSpeeds, "Failed!")
-	} else {
-		var dragTime string
-		var dragSpeed string
-		i := 0
-		for d[i] <= 1.0001 {
-			dragTime = strconv.FormatFloat((t[i]-t[0])/1000, 'f', 3, 32)
-			dragSpeed = strconv.FormatFloat(s[i]*2.237, 'f', 2, 32)
-			i++
-		}
-		dragTimes = append(dragTimes, dragTime)
-		dragSpeeds = append(dragSpeeds, dragSpeed)
-	}
-
-	return dragTimes, dragSpeeds
+// Returns an array of times (as strings) for 1/8mi, 1/4mi, 1/2mi and 1mi, along with an array of corresponding trap speeds for each
+func calcDragTimes(session *Session) (times []string, speeds []string) {
+	return calcDrag(session, defaultDragDistances).sheetValues()
 }
 
-// Calculate statistics during a race: Best lap time, track top speed, and lap sector times for La Selva Circuit
-// Returns Best Lap Time, followed by Track Top Speed, then an array of times for Sectors 1-4
-func calcRaceStats(csvFile string) (bestLapTime string, trackTopSpeed string, times []string) {
-	rows := readLog(csvFile)
-	// check data is received before doing anything, else will crash due to no data in the csv file
-	if len(rows) < 1 {
-		log.Fatalf("CSV File is empty!")
-	}
-
-	// Find row numbers based on column header names (row 0)
-	bestLapRow := 0
-	currentLapRow := 0
-	distanceRow := 0
-	lapNumRow := 0
-	speedRow := 0
-
-	for k, v := range rows[0] {
-		if v == "BestLap" {
-			bestLapRow = k
-		} else if v == "CurrentLap" {
-			currentLapRow = k
-		} else if v == "DistanceTraveled" {
-			distanceRow = k
-		} else if v == "LapNumber" {
-			lapNumRow = k
-		} else if v == "Speed" {
-			speedRow = k
-		}
+// Calculate statistics during a race: Best lap time, track top speed, and lap sector times for the given track
+// Returns Best Lap Time, followed by Track Top Speed, then an array of times for each of the track's sectors, and every lap
+func calcRaceStats(session *Session, track trackDefinition) (bestLapTime string, optimalGap string, trackTopSpeed string, times []string, laps []lapRecord) {
+	if err := session.Require("BestLap", "CurrentLap", "DistanceTraveled", "LapNumber", "Speed"); err != nil {
+		log.Fatalf("Race Mode needs lap data, which %s doesn't send. %v", session.Format.Game, err)
 	}
 
-	var t []float64  // array of current lap time values
-	var d []float64  // array of distance values
-	var l []float64  // array of lap number values
-	var s []float64  // array of speed values
-	var bl []float64 // array of BestLap time values
-
-	for i := range rows {
-		if i == 0 { // skip first row (header/column names)
-			continue
-		}
-
-		// Add current lap times from row to array
-		time, err := strconv.ParseFloat(rows[i][currentLapRow], 32)
-		check(err)
-		t = append(t, time)
-
-		// Add distance values from row to array
-		dist, err := strconv.ParseFloat(rows[i][distanceRow], 32)
-		check(err)
-		d = append(d, dist)
-
-		// Add current lap numbers from row to array
-		lap, err := strconv.ParseFloat(rows[i][lapNumRow], 32)
-		check(err)
-		l = append(l, lap)
+	// If the log has the track (only Forza Motorsport (2023) sends TrackOrdinal),
+	// only use data from the last track driven so laps from an earlier race don't get mixed in
+	if session.Has("TrackOrdinal") {
+		tracks := session.Int("TrackOrdinal")
+		fmt.Printf("Track Ordinal: %d\n", tracks[len(tracks)-1])
+	}
+	session = lastTrackData(session)
 
-		// Add speed value from row to array
-		speed, err := strconv.ParseFloat(rows[i][speedRow], 32)
-		check(err)
+	t := session.Float("CurrentLap")       // array of current lap time values
+	d := session.Float("DistanceTraveled") // array of distance values
+	l := session.Float("LapNumber")        // array of lap number values
+	bl := session.Float("BestLap")         // array of BestLap time values
+	var s []float64                        // array of speed values
+	for _, speed := range session.Float("Speed") {
 		s = append(s, (speed * 2.237)) // convert to MPH
-
-		// Add Best lap times from row to array
-		best, err := strconv.ParseFloat(rows[i][bestLapRow], 32)
-		check(err)
-		bl = append(bl, best)
 	}
 
 	// Find the best lap time
 	bestLap := bl[len(bl)-1]
 	// Check time at the end of the race if you're at the finish line
-	if d[len(d)-1]-(5951*l[len(l)-1]) > 5951 {
-		if t[len(t)-1]+0.0125 < bestLap {
-			bestLap = t[len(t)-1] + 0.0125 // The game seems to take this much extra time when finishing the race
+	if d[len(d)-1]-(track.Length*l[len(l)-1]) > track.Length {
+		if t[len(t)-1]+track.FinishOffset < bestLap {
+			bestLap = t[len(t)-1] + track.FinishOffset // The game seems to take this much extra time when finishing the race
 		}
 	}
-	// Convert to 00:00.000 time format
-	min := strconv.FormatFloat(math.Floor(bestLap/60), 'f', 0, 32)
-	if len(min) == 1 {
-		min = "0" + min
-	}
-	sec := strconv.FormatFloat(math.Mod(bestLap, 60), 'f', 3, 32)
-	secNum, _ := strconv.ParseFloat(sec, 8)
-	if secNum < 10 {
-		sec = "0" + sec
-	}
-	bestLapStr := min + ":" + sec
+	bestLapStr := formatLapTime(bestLap)
 
 	// Find the track top speed
 	sort.Float64s(s)
@@ -311,164 +87,74 @@ func calcRaceStats(csvFile string) (bestLapTime string, trackTopSpeed string, ti
 	topSpeedStr := strconv.FormatFloat(topSpeed, 'f', 2, 32)
 
 	// Calculate Track Sector Times
-	s1Time := 0.0
-	s2Time := 0.0
-	s3Time := 0.0
-	s4Time := 0.0
-	s1TimeTmp := 0.0
-	s2TimeTmp := 0.0
-	s3TimeTmp := 0.0
-	s4TimeTmp := 0.0
-	s1End := 0.0
-	s2End := 0.0
-	s3End := 0.0
-	dist := 0.0
-	endLapDist := 0.0
-
-	for i, val := range d {
-		if val < 0 {
-			continue
+	laps = calcLaps(session, track)
+	sectorTimes := make([]float64, track.SectorCount()) // sector times of the best lap
+	bestLapNumber := 0
+	for _, lap := range laps {
+		if lap.Time <= bestLap && lap.hasEverySector() { // Partial laps don't have every sector
+			copy(sectorTimes, lap.Sectors)
+			bestLapNumber = lap.Number
 		}
-		if i == 0 || l[i] != l[i-1] {
-			endLapDist = val
-		}
-		dist = val - endLapDist
-
-		if dist > 1878 && dist < 1879 {
-			s1End = t[i]
-			s1TimeTmp = s1End
-		} else if dist > 3184 && dist < 3185 {
-			s2End = t[i]
-			s2TimeTmp = s2End - s1End
-		} else if dist > 4311 && dist < 4312 {
-			s3End = t[i]
-			s3TimeTmp = s3End - s2End
-		} else if i == len(l)-1 || l[i] != l[i+1] {
-			s4TimeTmp = (t[i] - s3End) + 0.0125
-			if t[i] <= bestLap {
-				s1Time = s1TimeTmp
-				s2Time = s2TimeTmp
-				s3Time = s3TimeTmp
-				s4Time = s4TimeTmp
-			}
-		}
-	}
-
-	// Convert to hh:mm:ss.000 duration format
-	minutes := strconv.FormatFloat(math.Floor(s1Time/60), 'f', 0, 32)
-	seconds := strconv.FormatFloat(math.Mod(s1Time, 60), 'f', 3, 32)
-	if math.Mod(s1Time, 60) < 1 {
-		seconds = "0" + seconds
 	}
-	s1TimeStr := "00:" + minutes + ":" + seconds
 
-	minutes = strconv.FormatFloat(math.Floor(s2Time/60), 'f', 0, 32)
-	seconds = strconv.FormatFloat(math.Mod(s2Time, 60), 'f', 3, 32)
-	if math.Mod(s2Time, 60) < 1 {
-		seconds = "0" + seconds
+	// Time the micro-sectors between the track's timing gates, if it has any
+	if len(track.Gates) > 0 && session.Has("PositionX") {
+		printMicroSectors(calcMicroSectors(session, track), track, bestLapNumber)
 	}
-	s2TimeStr := "00:" + minutes + ":" + seconds
 
-	minutes = strconv.FormatFloat(math.Floor(s3Time/60), 'f', 0, 32)
-	seconds = strconv.FormatFloat(math.Mod(s3Time, 60), 'f', 3, 32)
-	if math.Mod(s3Time, 60) < 1 {
-		seconds = "0" + seconds
-	}
-	s3TimeStr := "00:" + minutes + ":" + seconds
+	// Find how far the best valid lap is from the best sectors put together. The game's
+	// best lap can be one the optimal laps leave out, which could put it ahead of them.
+	bestValid := bestValidLap(laps)
+	theoretical := theoreticalBestLap(laps, track.SectorCount())
+	theoretical.print("Theoretical Best Lap", bestValid)
+	rollingOptimalLap(laps, track.SectorCount()).print("Rolling Optimal Lap", bestValid)
 
-	minutes = strconv.FormatFloat(math.Floor(s4Time/60), 'f', 0, 32)
-	seconds = strconv.FormatFloat(math.Mod(s4Time, 60), 'f', 3, 32)
-	if math.Mod(s4Time, 60) < 1 {
-		seconds = "0" + seconds
+	// Convert to hh:mm:ss.000 duration format
+	var sectorTimeStrs []string
+	for _, v := range sectorTimes {
+		sectorTimeStrs = append(sectorTimeStrs, formatSectorTime(v))
 	}
-	s4TimeStr := "00:" + minutes + ":" + seconds
 
-	var sectorTimes []string
-	sectorTimes = append(sectorTimes, s1TimeStr, s2TimeStr, s3TimeStr, s4TimeStr)
-
-	return bestLapStr, topSpeedStr, sectorTimes
+	return bestLapStr, theoretical.gap(bestValid.Time), topSpeedStr, sectorTimeStrs, laps
 }
 
+// Power and torque unit conversions from what the game sends
+const (
+	wattsToHP = 0.0013410220888 // Watts to mechanical horsepower
+	nmToFtLb  = 0.7375621493    // Newton meters to ft-lb
+)
+
 // calculate stats
-func calculate(rows [][]string) (r [][]string, out []string) {
-	// Find row numbers based on column header names (row 0)
-	powerRow := 0
-	torqueRow := 0
-	speedRow := 0
-	boostRow := 0
-	classPIRow := 0
-	driveTrainRow := 0
-	timeRow := 0
-	gearRow := 0
-	classLetterRow := 0
-
-	for k, v := range rows[0] {
-		if v == "Speed" {
-			speedRow = k
-		} else if v == "Boost" {
-			boostRow = k
-		} else if v == "CarPerformanceIndex" {
-			classPIRow = k
-		} else if v == "DrivetrainType" {
-			driveTrainRow = k
-		} else if v == "Power" {
-			powerRow = k
-		} else if v == "Torque" {
-			torqueRow = k
-		} else if v == "TimestampMS" {
-			timeRow = k
-		} else if v == "Gear" {
-			gearRow = k
-		} else if v == "CarClass" {
-			classLetterRow = k
-		}
-	}
+func calculate(session *Session, timing intervalTiming) *statResults {
+	check(session.Require("TimestampMS", "Speed", "CarClass", "CarPerformanceIndex", "DrivetrainType"))
 
-	var output []string
-	carClass := rows[1][classLetterRow]
+	// The FM7 "sled" format has no power, torque, boost or gear data,
+	// so those stats are left as N/A and speed comes from the car's velocity
+	hasPower := session.Has("Power") && session.Has("Torque") && session.Has("Boost") && session.Has("Gear")
+	fmt.Printf("Detected game: %s (%s format)\n", session.Format.Game, session.Format.Name)
+
+	output := newStatResults()
+	first := session.Frame(0)
 	var t []float64  // array of timestamp values
-	var s []float64  // array of speed values
+	var s []float64  // array of speed values (meters/sec)
 	var b []float64  // array of boost values
 	var p []float64  // array of power values
 	var tq []float64 // array of torque values
 	var g []float64  // array of gear values
-	// fmt.Printf("%T\n", s) // print type
 
-	for i := range rows {
+	for i := 0; i < session.Len(); i++ {
+		frame := session.Frame(i)
+
+		t = append(t, (frame.Float("TimestampMS") / 1000)) // convert from milliseconds to seconds
+		s = append(s, frame.Float("Speed"))
 
-		if i == 0 { // skip first row (header/column names)
+		if !hasPower {
 			continue
 		}
-
-		// Add timestamps from row to array
-		time, err := strconv.ParseFloat(rows[i][timeRow], 32)
-		check(err)
-		t = append(t, (time / 1000)) // convert from milliseconds to seconds
-
-		// Add power value from row to array
-		power, err := strconv.ParseFloat(rows[i][powerRow], 32) // convert power string to int
-		check(err)
-		p = append(p, (power * 0.0013410220888)) // convert from Watts to Mechanical Horsepower
-
-		// Add torque value from row to array
-		torque, err := strconv.ParseFloat(rows[i][torqueRow], 32) // convert torque string to int
-		check(err)
-		tq = append(tq, (torque * 0.7375621493)) // convert from nm to ft-lb
-
-		// Add speed value from row to array
-		speed, err := strconv.ParseFloat(rows[i][speedRow], 32) // convert speed string to int
-		check(err)
-		s = append(s, (speed * 2.237)) // convert to MPH
-
-		// Add boost value from row to array
-		boost, err := strconv.ParseFloat(rows[i][boostRow], 32) // convert boost string to int
-		check(err)
-		b = append(b, boost) // convert to PSI, not 100% sure what value this is natively?
-
-		// Add gear value from row to array
-		gear, err := strconv.ParseFloat(rows[i][gearRow], 32) // convert speed string to int
-		check(err)
-		g = append(g, (gear))
+		p = append(p, (frame.Float("Power") * wattsToHP))   // convert from Watts to Mechanical Horsepower
+		tq = append(tq, (frame.Float("Torque") * nmToFtLb)) // convert from nm to ft-lb
+		b = append(b, frame.Float("Boost"))                 // convert to PSI, not 100% sure what value this is natively?
+		g = append(g, frame.Float("Gear"))
 	}
 
 	var totalSpeed float64
@@ -482,13 +168,16 @@ func calculate(rows [][]string) (r [][]string, out []string) {
 	//fmt.Printf("Average speed: %.2f MPH \n", totalSpeed/float64(len(s))) // truncate to 2 decimal places
 
 	//Get PI Index Number
-	pINum := rows[1][classPIRow]
-	output = append(output, pINum)
+	pINum := strconv.Itoa(first.Int("CarPerformanceIndex"))
+	output.add("PI", pINum)
+
+	// Get Class Letter
+	classLetter := session.Format.ClassLetter(first.Int("CarClass"))
+	output.add("Class", classLetter)
 
 	//Get Drivetrain Type
 	drivetrainStr := ""
-	drivetrainNum, err := strconv.ParseInt(rows[1][driveTrainRow], 10, 32)
-	check(err)
+	drivetrainNum := first.Int("DrivetrainType")
 	if drivetrainNum == 0 {
 		drivetrainStr = "FWD"
 	} else if drivetrainNum == 1 {
@@ -496,12 +185,16 @@ func calculate(rows [][]string) (r [][]string, out []string) {
 	} else if drivetrainNum == 2 {
 		drivetrainStr = "AWD"
 	}
-	output = append(output, drivetrainStr)
+	output.add("Drivetrain", drivetrainStr)
 
 	// Get peak horsepower
 	// Only looks at power numbers when the car is in 2nd gear or higher,
 	// because when bouncing off the rev limiter during a launch the game
 	// will output higher horsepower numbers than the car actually has.
+	if !hasPower {
+		output.add("Peak Power (hp)", "N/A")
+		output.add("Peak Torque (ft-lb)", "N/A")
+	}
 	var adjustedPowers []float64
 	var gearTotal float64
 	for _, value := range g {
@@ -516,216 +209,198 @@ func calculate(rows [][]string) (r [][]string, out []string) {
 			adjustedPowers = append(adjustedPowers, value) // add that power number to the adjusted list
 		}
 	}
-	sort.Float64s(adjustedPowers)
-	topPower := adjustedPowers[len(adjustedPowers)-1]
-	output = append(output, strconv.FormatFloat(topPower, 'f', 0, 32))
-
-	// Get peak torque
-	sort.Float64s(tq)
-	topTorque := tq[len(tq)-1]
-	output = append(output, strconv.FormatFloat(topTorque, 'f', 0, 32))
-
-	// Get 0-60mph time
-	zeroTo60, err := getTimeBetween(0, 60, t, s)
-	if err != nil {
-		output = append(output, "Failed!")
-	} else {
-		output = append(output, strconv.FormatFloat(zeroTo60, 'f', 3, 32))
-	}
-
-	// Get 0-100mph time
-	zeroTo100, err := getTimeBetween(0, 100, t, s)
-	if err != nil {
-		output = append(output, "Failed!")
-	} else {
-		output = append(output, strconv.FormatFloat(zeroTo100, 'f', 3, 32))
-	}
-
-	// CUSTOMIZED FORMAT TO REVERT LATER
-	if len(carClass) != 0 {
-		// Get 50-100mph time
-		fiftyTo100, err := getTimeBetween(50, 100, t, s)
-		if err != nil {
-			output = append(output, "Failed!")
-		} else {
-			output = append(output, strconv.FormatFloat(fiftyTo100, 'f', 3, 32))
-		}
-
-		// Get 60-150mph time
-		sixtyTo150, err := getTimeBetween(60, 150, t, s)
-		if err != nil {
-			output = append(output, "Failed!")
-		} else {
-			output = append(output, strconv.FormatFloat(sixtyTo150, 'f', 3, 32))
-		}
-
-		// Get 100-200mph time
-		hundredTo200, err := getTimeBetween(100, 200, t, s)
-		if err != nil {
-			output = append(output, "Failed!")
-		} else {
-			output = append(output, strconv.FormatFloat(hundredTo200, 'f', 3, 32))
-		}
+	if len(adjustedPowers) == 0 { // Never left 1st gear
+		adjustedPowers = p
 	}
+	if hasPower {
+		sort.Float64s(adjustedPowers)
+		topPower := adjustedPowers[len(adjustedPowers)-1]
+		output.add("Peak Power (hp)", strconv.FormatFloat(topPower, 'f', 0, 32))
 
-	/*if carClass == "0" || carClass == "1" { // D Class or C Class
-		// Get 25-75mph time
-		twentyfiveTo75, err := getTimeBetween(25, 75, t, s)
-		if err != nil {
-			output = append(output, "Failed!")
-		} else {
-			output = append(output, strconv.FormatFloat(twentyfiveTo75, 'f', 3, 32))
-		}
-
-		// Get 50-100mph time
-		fiftyTo100, err := getTimeBetween(50, 100, t, s)
-		if err != nil {
-			output = append(output, "Failed!")
-		} else {
-			output = append(output, strconv.FormatFloat(fiftyTo100, 'f', 3, 32))
-		}
-	} else if carClass == "2" || carClass == "3" { // B Class or A Class
-		// Get 50-100mph time
-		fiftyTo100, err := getTimeBetween(50, 100, t, s)
-		if err != nil {
-			output = append(output, "Failed!")
-		} else {
-			output = append(output, strconv.FormatFloat(fiftyTo100, 'f', 3, 32))
-		}
-
-		// Get 60-150mph time
-		sixtyTo150, err := getTimeBetween(60, 150, t, s)
-		if err != nil {
-			output = append(output, "Failed!")
-		} else {
-			output = append(output, strconv.FormatFloat(sixtyTo150, 'f', 3, 32))
-		}
-	} else { // S1, S2 and X Class
-		// Get 60-150mph time
-		sixtyTo150, err := getTimeBetween(60, 150, t, s)
-		if err != nil {
-			output = append(output, "Failed!")
-		} else {
-			output = append(output, strconv.FormatFloat(sixtyTo150, 'f', 3, 32))
-		}
-
-		// Get 100-200mph time
-		hundredTo200, err := getTimeBetween(100, 200, t, s)
-		if err != nil {
-			output = append(output, "Failed!")
-		} else {
-			output = append(output, strconv.FormatFloat(hundredTo200, 'f', 3, 32))
-		}
-	}*/
-
-	// Get 60-0mph time
-	sixtytoZero, err := getTimeBetween(60, 0, t, s)
-	if err != nil {
-		output = append(output, "Failed!")
-	} else {
-		output = append(output, strconv.FormatFloat(sixtytoZero, 'f', 3, 32))
+		// Get peak torque
+		sort.Float64s(tq)
+		topTorque := tq[len(tq)-1]
+		output.add("Peak Torque (ft-lb)", strconv.FormatFloat(topTorque, 'f', 0, 32))
 	}
 
-	// Get 100-0mph time
-	hundredToZero, err := getTimeBetween(100, 0, t, s)
-	if err != nil {
-		output = append(output, "Failed!")
-	} else {
-		output = append(output, strconv.FormatFloat(hundredToZero, 'f', 3, 32))
-	}
+	// Get acceleration and braking times (0-60 mph, 60-0 mph, etc.)
+	// Which intervals are timed depends on the car's class, since slower cars
+	// never reach 200 mph and faster cars are through 25-75 mph in no time
+	intervals := timing.Config.forClass(session.Format, classLetter)
+	timeIntervals(output, intervals, timing.Smoothing, t, s)
 
 	// Get top speed
 	// fmt.Println(s)
 	sort.Float64s(s)
-	topSpeed := s[len(s)-1]
+	topSpeed := s[len(s)-1] * speedUnits["mph"]
 	//fmt.Printf("Top speed: %.2f MPH \n", topSpeed)
-	output = append(output, strconv.FormatFloat(topSpeed, 'f', 2, 32))
+	output.add("Top Speed (mph)", strconv.FormatFloat(topSpeed, 'f', 2, 32))
 
 	// Get peak boost
-	sort.Float64s(b)
-	topBoost := b[len(b)-1]
-	//fmt.Printf("Peak boost: %.2f PSI \n", topBoost)
-	output = append(output, strconv.FormatFloat(topBoost, 'f', 1, 32))
+	if !hasPower {
+		output.add("Peak Boost", "N/A")
+	} else {
+		sort.Float64s(b)
+		topBoost := b[len(b)-1]
+		//fmt.Printf("Peak boost: %.2f PSI \n", topBoost)
+		output.add("Peak Boost", strconv.FormatFloat(topBoost, 'f', 1, 32))
+	}
 
-	return rows, output
+	return output
 }
 
 // Returns a floating point number representing how fast (in seconds)
-// the car traveled from the start speed to the end speed. (ex: 0-60 mph or 100-0 mph)
+// the car traveled from the start speed to the end speed (ex: 0-60 mph or 100-0 mph),
+// and the uncertainty of that time in seconds.
+// The moment each speed is crossed is interpolated between the data points either
+// side of it, so times aren't limited to the ~16 ms between packets. A speed of 0
+// is crossed when the car leaves (or comes to) a standstill, below 0.1 mph.
+// Acceleration is timed over the first time the car reaches the end speed, and
+// braking over the last time it slows to the end speed.
 // Returns an error if car does not reach either speed.
-func getTimeBetween(startSpeed float64, endSpeed float64, timeValues []float64, speedValues []float64) (float64, error) {
-	var startTime float64
-	var endTime float64
-	var speeds []float64
-
+func getTimeBetween(startSpeed float64, endSpeed float64, timeValues []float64, speedValues []float64) (float64, float64, error) {
 	if len(timeValues) != len(speedValues) {
-		return 0, errors.New("Time Array and Speed Array lengths do not match")
+		return 0, 0, errors.New("Time Array and Speed Array lengths do not match")
 	}
+	startLevel := math.Max(startSpeed, standstillLevel)
+	endLevel := math.Max(endSpeed, standstillLevel)
 
-	for _, value := range speedValues {
-		speeds = append(speeds, value)
+	// Also fails if the log starts with the car already past the start speed,
+	// since the moment it crossed it wasn't recorded
+	crossings := findCrossings(startLevel, endLevel, speedValues)
+	if len(crossings) == 0 {
+		return 0, 0, errors.New("Start or End Speed is outside of data range.")
+	}
+	crossing := crossings[0]
+	if startSpeed > endSpeed {
+		crossing = crossings[len(crossings)-1]
 	}
-	sort.Float64s(speeds)
-	minSpeed := speeds[0]
-	maxSpeed := speeds[len(speeds)-1]
 
-	// If either the Start or End speed is beyond what the car actually performed, returns an Error.
-	if startSpeed > maxSpeed+0.1 || endSpeed > maxSpeed+0.1 || (minSpeed > 0.1 && startSpeed < minSpeed) || (minSpeed > 0.1 && endSpeed < minSpeed) {
-		return 0, errors.New("Start or End Speed is outside of data range.")
+	startTime, startError := crossingTime(startLevel, crossing.Start, timeValues, speedValues)
+	endTime, endError := crossingTime(endLevel, crossing.End, timeValues, speedValues)
+	if startTime > endTime {
+		return 0, 0, errors.New("Calculated negative time, something went wrong with the data input.")
 	}
+	return endTime - startTime, math.Sqrt(startError*startError + endError*endError), nil
+}
 
-	if startSpeed > endSpeed { // If startSpeed > endSpeed, then we're calculating deceleration (ex: 60-0mph)
-		for i := 0; i < len(speedValues); i++ {
-			if speedValues[i] > startSpeed+0.1 {
-				startTime = timeValues[i]
-			} else if speedValues[i] > endSpeed+0.1 {
-				endTime = timeValues[i]
+// A time the car went from one speed to another: the data points just after it
+// crossed the start speed and just after it crossed the end speed
+type speedCrossing struct {
+	Start int
+	End   int
+}
+
+// Returns every time the car went from the start speed to the end speed (speeding
+// up if startLevel < endLevel, slowing down otherwise) without going back past the start speed
+func findCrossings(startLevel float64, endLevel float64, speedValues []float64) []speedCrossing {
+	var crossings []speedCrossing
+	start := -1 // data point after the car last crossed the start speed, -1 if it hasn't yet
+	for i := 1; i < len(speedValues); i++ {
+		prev, cur := speedValues[i-1], speedValues[i]
+		if startLevel < endLevel { // Acceleration (ex: 0-60mph)
+			if prev < startLevel && cur >= startLevel {
+				start = i
+			}
+			if start >= 0 && prev < endLevel && cur >= endLevel {
+				crossings = append(crossings, speedCrossing{Start: start, End: i})
+				start = -1
+			}
+		} else { // Deceleration (ex: 60-0mph)
+			if prev > startLevel && cur <= startLevel {
+				start = i
+			}
+			if start >= 0 && prev > endLevel && cur <= endLevel {
+				crossings = append(crossings, speedCrossing{Start: start, End: i})
+				start = -1
 			}
 		}
-	} else { // Otherwise, we're calculating acceleration (ex: 0-60mph)
-		// This solution stops after finding the first moment the car passes the start speed and ignores
-		// possible low speeds later on, so that the startTime isn't greater than the endTime.
-		// (For best usage this assumes a test run accelerating from 0mph to Top Speed, then back to 0mph)
-		i := 0
-		for speedValues[i] < startSpeed+0.1 {
-			startTime = timeValues[i]
-			i++
-		}
-		j := 0
-		for speedValues[j] < endSpeed+0.1 {
-			endTime = timeValues[j]
-			j++
+	}
+	return crossings
+}
+
+// Speed (in the interval's unit) below which the car counts as stopped when timing from or to 0
+const standstillLevel = 0.1
+
+// TimestampMS only counts whole milliseconds, so every crossing time is also
+// uncertain by the standard deviation of rounding to 1 ms
+var timestampError = 0.001 / math.Sqrt(12)
+
+// Returns the time the speed crossed level between data points i-1 and i, using
+// linear interpolation, and an estimate of its error. The error is how far the
+// linear estimate is from one that also follows the curve of a third data point,
+// plus the timestamp rounding, and is never more than the time between the two points.
+func crossingTime(level float64, i int, timeValues []float64, speedValues []float64) (float64, float64) {
+	t0, t1 := timeValues[i-1], timeValues[i]
+	s0, s1 := speedValues[i-1], speedValues[i]
+	linear := t1
+	if s1 != s0 {
+		linear = t0 + (level-s0)/(s1-s0)*(t1-t0)
+	}
+
+	// Fit a quadratic through the nearest third data point, with time as a function of speed
+	k := i + 1
+	if k >= len(speedValues) {
+		k = i - 2
+	}
+	curveError := (t1 - t0) / 2 // Worst case, if the curve can't be fitted
+	if k >= 0 {
+		t2, s2 := timeValues[k], speedValues[k]
+		if s0 != s1 && s0 != s2 && s1 != s2 {
+			quadratic := t0*(level-s1)*(level-s2)/((s0-s1)*(s0-s2)) +
+				t1*(level-s0)*(level-s2)/((s1-s0)*(s1-s2)) +
+				t2*(level-s0)*(level-s1)/((s2-s0)*(s2-s1))
+			if quadratic >= t0 && quadratic <= t1 {
+				curveError = math.Abs(quadratic - linear)
+			}
 		}
 	}
+	return linear, math.Min(math.Sqrt(curveError*curveError+timestampError*timestampError), t1-t0)
+}
 
-	if startTime > endTime {
-		return 0, errors.New("Calculated negative time, something went wrong with the data input.")
+// Returns speeds smoothed with a centered moving average over window data points
+// (rounded up to an odd number), to take out noise before timing intervals.
+// A window of 1 or less returns the speeds unchanged.
+func smoothSpeeds(speeds []float64, window int) []float64 {
+	if window <= 1 {
+		return speeds
+	}
+	half := window / 2
+	smoothed := make([]float64, len(speeds))
+	for i := range speeds {
+		from, to := i-half, i+half
+		if from < 0 {
+			from = 0
+		}
+		if to > len(speeds)-1 {
+			to = len(speeds) - 1
+		}
+		total := 0.0
+		for _, v := range speeds[from : to+1] {
+			total += v
+		}
+		smoothed[i] = total / float64(to-from+1)
 	}
-	return (endTime - startTime), nil
+	return smoothed
 }
 
 // Returns the Ordinal Number of the current car, or the first car used during data collection.
-func getOrdinalNumber(csvFile string) (string, error) {
-	rows := readLog(csvFile)
-	if len(rows) < 1 {
-		return "", errors.New("csvFile is empty.")
+func getOrdinalNumber(session *Session) (string, error) {
+	if session.Len() < 1 {
+		return "", errors.New("Log is empty.")
 	}
-	return rows[1][53], nil
+	return strconv.Itoa(session.Frame(0).Int("CarOrdinal")), nil
 }
 
-func getAllOrdinalNumbers(csvFile string) ([]string, error) {
-	rows := readLog(csvFile)
-	if len(rows) < 1 {
-		return nil, errors.New("csvFile is empty.")
+func getAllOrdinalNumbers(session *Session) ([]string, error) {
+	if session.Len() < 1 {
+		return nil, errors.New("Log is empty.")
 	}
 	var o []string
 	lastVal := ""
-	for i := range rows {
-		if i == 0 { // skip first row (header/column names)
-			continue
-		}
-		// Add Ordinals from row to array
-		num := rows[i][53]
+	for _, v := range session.Int("CarOrdinal") {
+		// Add Ordinals to array
+		num := strconv.Itoa(v)
 		if lastVal == "" || num != lastVal {
 			o = append(o, num)
 			lastVal = num
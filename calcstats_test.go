@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCrossingTime(t *testing.T) {
+	tests := []struct {
+		name   string
+		level  float64
+		i      int
+		times  []float64
+		speeds []float64
+		want   float64
+		error  float64
+	}{
+		{"steady acceleration", 5, 1, []float64{0, 1, 2}, []float64{0, 10, 20}, 0.5, timestampError},
+		{"level on a data point", 10, 1, []float64{0, 1, 2}, []float64{0, 10, 20}, 1, timestampError},
+		{"deceleration", 15, 2, []float64{0, 1, 2}, []float64{30, 20, 10}, 1.5, timestampError},
+		{"flat speed", 5, 1, []float64{0, 1, 2}, []float64{5, 5, 10}, 1, math.Sqrt(0.25 + timestampError*timestampError)},
+		// Speed = time², so the linear estimate is off from the curve through the third point
+		{"curve", 2.25, 2, []float64{0, 1, 2}, []float64{0, 1, 4}, 1 + 1.25/3, math.Sqrt(math.Pow(1.78125-(1+1.25/3), 2) + timestampError*timestampError)},
+		{"flat speed, short gap", 5, 1, []float64{0, 0.001, 2}, []float64{5, 5, 10}, 0.001, math.Sqrt(0.0005*0.0005 + timestampError*timestampError)},
+		{"error capped at the gap", 5, 1, []float64{0, 0.0002, 2}, []float64{5, 5, 10}, 0.0002, 0.0002},
+	}
+	for _, test := range tests {
+		got, gotError := crossingTime(test.level, test.i, test.times, test.speeds)
+		if math.Abs(got-test.want) > 1e-9 || math.Abs(gotError-test.error) > 1e-9 {
+			t.Errorf("%s: crossingTime = %.6f ± %.6f, want %.6f ± %.6f", test.name, got, gotError, test.want, test.error)
+		}
+	}
+}
+
+func TestGetTimeBetween(t *testing.T) {
+	times := []float64{0, 1, 2, 3, 4, 5, 6}
+	tests := []struct {
+		name   string
+		from   float64
+		to     float64
+		speeds []float64
+		want   float64
+		fails  bool
+	}{
+		{"acceleration", 10, 50, []float64{0, 20, 40, 60, 60, 60, 60}, 2, false},
+		{"from a standstill", 0, 30, []float64{0, 0, 20, 40, 60, 60, 60}, 1.5 - standstillLevel/20, false},
+		{"deceleration uses the last stop", 60, 0, []float64{70, 30, 0, 70, 70, 30, 0}, 1.75 - standstillLevel/30, false},
+		{"starts above the start speed", 10, 50, []float64{20, 40, 60, 60, 60, 60, 60}, 0, true},
+		{"never reaches the end speed", 10, 100, []float64{0, 20, 40, 60, 60, 60, 60}, 0, true},
+	}
+	for _, test := range tests {
+		got, _, err := getTimeBetween(test.from, test.to, times, test.speeds)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: getTimeBetween = %.6f, want an error", test.name, got)
+			}
+			continue
+		}
+		if err != nil || math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: getTimeBetween = %.6f (%v), want %.6f", test.name, got, err, test.want)
+		}
+	}
+}
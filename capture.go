@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// Capture files store every Data Out datagram exactly as it was received, so old
+// sessions can be decoded again later without losing any precision.
+//
+// Layout (all numbers little endian):
+//
+//	8 byte magic "FZCAP\x00\x00\x01"
+//	then for each datagram:
+//	  int64  receive time (nanoseconds since the Unix epoch)
+//	  uint16 datagram length
+//	  datagram bytes
+var captureMagic = []byte("FZCAP\x00\x00\x01")
+
+// A single datagram read from a capture file
+type captureRecord struct {
+	Time time.Time
+	Data []byte
+}
+
+// Writes datagrams to a capture file
+type captureWriter struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+// Creates a new capture file, replacing any existing file with the same name
+func createCapture(name string) (*captureWriter, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	if _, err := w.Write(captureMagic); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &captureWriter{f: f, w: w}, nil
+}
+
+// Adds a datagram and the time it was received to the capture
+func (c *captureWriter) Write(received time.Time, b []byte) error {
+	var header [10]byte
+	binary.LittleEndian.PutUint64(header[0:], uint64(received.UnixNano()))
+	binary.LittleEndian.PutUint16(header[8:], uint16(len(b)))
+	if _, err := c.w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := c.w.Write(b)
+	return err
+}
+
+// Flushes any buffered datagrams and closes the file
+func (c *captureWriter) Close() error {
+	if err := c.w.Flush(); err != nil {
+		c.f.Close()
+		return err
+	}
+	return c.f.Close()
+}
+
+// Reads every datagram from a capture file
+func readCapture(name string) ([]captureRecord, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != string(captureMagic) {
+		return nil, fmt.Errorf("'%s' is not a capture file", name)
+	}
+
+	var records []captureRecord
+	var header [10]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return records, errors.New("Capture file is truncated")
+		}
+		received := time.Unix(0, int64(binary.LittleEndian.Uint64(header[0:])))
+		data := make([]byte, binary.LittleEndian.Uint16(header[8:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return records, errors.New("Capture file is truncated")
+		}
+		records = append(records, captureRecord{Time: received, Data: data})
+	}
+	return records, nil
+}
+
+// Decodes a capture file into a session, the same as readLog does for a CSV log
+// but keeping the exact values the game sent. Packets are filtered the
+// same way Listen Mode does, so the result matches the log that was written live.
+func replayCapture(name string, format *PacketFormat, evMode bool) *Session {
+	records, err := readCapture(name)
+	if err != nil && len(records) == 0 {
+		log.Fatalf("Cannot read capture '%s': %s\n", name, err.Error())
+	} else if err != nil {
+		log.Printf("%s, using the first %d datagrams\n", err.Error(), len(records))
+	}
+
+	var session *Session
+	filter := newSessionFilter(format, evMode)
+	for _, record := range records {
+		packet, ok := filter.accept(record.Data)
+		if !ok {
+			continue
+		}
+		if session == nil {
+			session = newSession(filter.Format, filter.Format.Headers())
+		}
+		session.appendPacket(packet)
+	}
+	if session == nil {
+		log.Fatalf("No usable packets in capture '%s'", name)
+	}
+	fmt.Printf("Replayed %d of %d datagrams from %s\n", session.Len(), len(records), name)
+	filter.printRejected()
+	session.addDerivedChannels()
+	return session
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "session.fzcap")
+	start := time.Unix(1700000000, 123456789)
+	var datagrams [][]byte
+	for _, format := range []string{"fm7sled", "fm7dash", "fh", "fm8"} {
+		datagrams = append(datagrams, testPacket(packetFormats[format]).Encode())
+	}
+	datagrams = append(datagrams, []byte{}, []byte("not a packet"))
+
+	capture, err := createCapture(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, b := range datagrams {
+		if err := capture.Write(start.Add(time.Duration(k)*time.Millisecond), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := readCapture(name)
+	if err != nil || len(records) != len(datagrams) {
+		t.Fatalf("readCapture = %d records (%v), want %d", len(records), err, len(datagrams))
+	}
+	for k, record := range records {
+		if !record.Time.Equal(start.Add(time.Duration(k)*time.Millisecond)) || !bytes.Equal(record.Data, datagrams[k]) {
+			t.Errorf("record %d = %v %d bytes, want %v %d bytes", k, record.Time, len(record.Data), start.Add(time.Duration(k)*time.Millisecond), len(datagrams[k]))
+		}
+	}
+
+	// A capture cut off part way through a datagram keeps the datagrams before it
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, b[:len(b)-3], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if records, err := readCapture(name); err == nil || len(records) != len(datagrams)-1 {
+		t.Errorf("readCapture of a truncated capture = %d records (%v), want %d and an error", len(records), err, len(datagrams)-1)
+	}
+	if err := ioutil.WriteFile(name, []byte("log.csv"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCapture(name); err == nil {
+		t.Errorf("readCapture of a file that isn't a capture didn't fail")
+	}
+}
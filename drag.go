@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	dragLiftMS         = 1000        // A pull ends once the throttle has been off this long
+	trapLength         = 66 * 0.3048 // Meters, the trap speed is averaged over the last 66 ft before the mark, as at a real strip
+	distanceCheckError = 0.01        // Integrated distance further than this fraction from DistanceTraveled gets a warning
+	dragSameDistance   = 0.5         // Meters, distances closer than this are timed once
+	dragLaunchDistance = 60 * 0.3048 // Meters, braking before this is part of the launch (e.g. brake torquing), not the end of the run
+)
+
+// A distance timed in Drag Mode
+type dragDistance struct {
+	Name   string
+	Meters float64
+}
+
+// Distances timed in Drag Mode, in order: a drag strip's incremental timers plus
+// the half mile and mile
+var defaultDragDistances = []dragDistance{
+	{"60 ft", 60 * 0.3048},
+	{"330 ft", 330 * 0.3048},
+	{"1/8 mile", 660 * 0.3048},
+	{"1000 ft", 1000 * 0.3048},
+	{"1/4 mile", 1320 * 0.3048},
+	{"1/2 mile", 2640 * 0.3048},
+	{"1 mile", 5280 * 0.3048},
+}
+
+// Distances that have columns on the Stat Builder sheet, in order
+var dragSheetDistances = []string{"1/8 mile", "1/4 mile", "1/2 mile", "1 mile"}
+
+// Meters in each distance unit
+var distanceUnits = map[string]float64{
+	"m":     1,
+	"km":    1000,
+	"ft":    0.3048,
+	"mi":    1609.344,
+	"mile":  1609.344,
+	"miles": 1609.344,
+}
+
+// Parses a distance such as "100m", "402 m", "1km", "60ft" or "1/4 mi".
+// The number can be a fraction, and the unit is one of distanceUnits.
+func parseDragDistance(s string) (dragDistance, error) {
+	s = strings.TrimSpace(s)
+	split := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.' && r != '/'
+	})
+	if split <= 0 {
+		return dragDistance{}, fmt.Errorf("Drag distance %q needs a number and a unit (m, km, ft or mi)", s)
+	}
+	number, unit := s[:split], strings.TrimSpace(s[split:])
+	meters, isPresent := distanceUnits[unit]
+	if !isPresent {
+		return dragDistance{}, fmt.Errorf("Drag distance %q has an unknown unit %q (use m, km, ft or mi)", s, unit)
+	}
+
+	var value float64
+	var err error
+	if parts := strings.SplitN(number, "/", 2); len(parts) == 2 {
+		numerator, err1 := strconv.ParseFloat(parts[0], 64)
+		denominator, err2 := strconv.ParseFloat(parts[1], 64)
+		if err1 != nil || err2 != nil || denominator == 0 {
+			return dragDistance{}, fmt.Errorf("Drag distance %q is not a number", s)
+		}
+		value = numerator / denominator
+	} else if value, err = strconv.ParseFloat(number, 64); err != nil {
+		return dragDistance{}, fmt.Errorf("Drag distance %q is not a number", s)
+	}
+	if value <= 0 {
+		return dragDistance{}, fmt.Errorf("Drag distance %q must be more than 0", s)
+	}
+	if unit == "mile" || unit == "miles" {
+		unit = "mi"
+	}
+	return dragDistance{Name: number + " " + unit, Meters: value * meters}, nil
+}
+
+// Returns the default drag distances along with the comma separated list of
+// extra distances, in order from shortest to longest. Extra distances with the
+// same name as one already in the list, or within dragSameDistance of its
+// length (e.g. 402m and the 1/4 mile), are left out.
+func dragDistances(extra string) ([]dragDistance, error) {
+	distances := append([]dragDistance(nil), defaultDragDistances...)
+	for _, s := range strings.Split(extra, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		distance, err := parseDragDistance(s)
+		if err != nil {
+			return nil, err
+		}
+		duplicate := false
+		for _, d := range distances {
+			if d.Name == distance.Name || math.Abs(d.Meters-distance.Meters) < dragSameDistance {
+				duplicate = true
+			}
+		}
+		if !duplicate {
+			distances = append(distances, distance)
+		}
+	}
+	sort.SliceStable(distances, func(a, b int) bool {
+		return distances[a].Meters < distances[b].Meters
+	})
+	return distances, nil
+}
+
+// The time and trap speed at one distance
+type dragResult struct {
+	Distance  dragDistance
+	Time      float64 // Seconds from the launch
+	TrapSpeed float64 // m/s, averaged over the last trapLength before the mark
+	Found     bool    // False if the car didn't travel this far
+}
+
+// Every distance timed over one run
+type dragRun struct {
+	Results  []dragResult
+	Distance float64 // Meters, integrated from Speed
+	Reported float64 // Meters the game's DistanceTraveled went up by over the run, 0 if the log doesn't have it
+}
+
+// Times each distance from the moment the car leaves a standstill. Distance is
+// integrated from Speed with the trapezoidal rule, and the moment each distance is
+// reached is solved for exactly within the data point it falls in (assuming the
+// speed changes steadily between data points). The trap speed is the trap length
+// divided by the time taken to cover it, ending at the mark, like the timing
+// beams at a real strip (distances shorter than the trap use the whole distance).
+func calcDrag(session *Session, distances []dragDistance) dragRun {
+	check(session.Require("TimestampMS", "Speed"))
+	run := dragRun{}
+	for _, distance := range distances {
+		run.Results = append(run.Results, dragResult{Distance: distance})
+	}
+
+	allSpeeds := session.Float("Speed") // kept as meters/sec
+	startIndex := 0
+	for startIndex < len(allSpeeds) && allSpeeds[startIndex] < standstillSpeed {
+		startIndex++ // Increase until we find the index at which the car actually starts moving
+	}
+	if startIndex == len(allSpeeds) { // Car never moved
+		return run
+	}
+	if startIndex > 0 {
+		startIndex-- // Start from the last data point the car was stopped at
+	}
+
+	t := session.Float("TimestampMS")[startIndex:]
+	s := allSpeeds[startIndex:]
+	seconds := make([]float64, len(t))
+	d := make([]float64, len(t)) // distance traveled at each data point, in meters
+	for i := range t {
+		seconds[i] = (t[i] - t[0]) / 1000
+		if i > 0 {
+			d[i] = d[i-1] + (seconds[i]-seconds[i-1])*(s[i-1]+s[i])/2
+		}
+	}
+	run.Distance = d[len(d)-1]
+	if reported := session.Float("DistanceTraveled"); reported != nil {
+		run.Reported = reported[len(reported)-1] - reported[startIndex]
+	}
+
+	for k := range run.Results {
+		result := &run.Results[k]
+		end, ok := timeAtDistance(result.Distance.Meters, seconds, s, d)
+		if !ok {
+			continue
+		}
+		trap := math.Min(trapLength, result.Distance.Meters)
+		start, _ := timeAtDistance(result.Distance.Meters-trap, seconds, s, d)
+		result.Time = end
+		result.TrapSpeed = trap / (end - start)
+		result.Found = true
+	}
+	return run
+}
+
+// Returns the time the car reached a distance (meters), solving for it within the
+// data point it falls in, and false if the car never got that far
+func timeAtDistance(distance float64, seconds []float64, speeds []float64, d []float64) (float64, bool) {
+	if distance <= 0 {
+		return 0, true
+	}
+	i := sort.SearchFloat64s(d, distance)
+	if i == 0 || i >= len(d) {
+		return 0, false
+	}
+	// With speed changing steadily from v0 to v1 over dt, the distance covered after
+	// τ seconds is v0·τ + (v1-v0)/(2·dt)·τ²
+	dt := seconds[i] - seconds[i-1]
+	v0, v1 := speeds[i-1], speeds[i]
+	remaining := distance - d[i-1]
+	a := (v1 - v0) / (2 * dt)
+	tau := remaining / v0
+	if math.Abs(a) > 1e-9 {
+		// Rounding can take the discriminant just below 0 when slowing to a stop at the end of the data point
+		tau = (-v0 + math.Sqrt(math.Max(0, v0*v0+4*a*remaining))) / (2 * a)
+	}
+	return seconds[i-1] + math.Max(0, math.Min(tau, dt)), true
+}
+
+// Returns the times and trap speeds (mph) of the distances with sheet columns,
+// formatted for the sheet, with "Failed!" for distances the car didn't reach
+func (run dragRun) sheetValues() (times []string, speeds []string) {
+	for _, name := range dragSheetDistances {
+		time, speed := "Failed!", "Failed!"
+		if result, ok := run.result(name); ok {
+			time = strconv.FormatFloat(result.Time, 'f', 3, 32)
+			speed = strconv.FormatFloat(result.TrapSpeed*speedUnits["mph"], 'f', 2, 32)
+		}
+		times = append(times, time)
+		speeds = append(speeds, speed)
+	}
+	return times, speeds
+}
+
+// Prints every distance's time and trap speed, and warns if the integrated
+// distance doesn't match the game's DistanceTraveled
+func (run dragRun) print(indent string) {
+	for _, result := range run.Results {
+		if !result.Found {
+			fmt.Printf("%s%s: Failed!\n", indent, result.Distance.Name)
+			continue
+		}
+		fmt.Printf("%s%s: %.3f s @ %.2f mph (%.1f km/h)\n", indent, result.Distance.Name,
+			result.Time, result.TrapSpeed*speedUnits["mph"], result.TrapSpeed*speedUnits["km/h"])
+	}
+	if run.Reported > 0 && math.Abs(run.Distance-run.Reported) > distanceCheckError*run.Reported {
+		fmt.Printf("%sWarning: distance from Speed (%.1f m) doesn't match DistanceTraveled (%.1f m)\n", indent, run.Distance, run.Reported)
+	}
+}
+
+// Splits a session into drag runs. A run starts when the car launches from a
+// standstill and ends at the end of that pull: when the brakes go on (once the
+// car is dragLaunchDistance down the strip, so a brake torque launch doesn't end
+// it), the throttle has been off for dragLiftMS, or the car stops again.
+func splitDragRuns(session *Session) []*Session {
+	t := session.Float("TimestampMS")
+	s := session.Float("Speed")
+	brake := session.Float("Brake")
+	throttle := session.Float("Accel")
+
+	var runs []*Session
+	addRun := func(start int, end int) { // end included
+		if end-start+1 >= minSegmentRows {
+			runs = append(runs, session.Slice(start, end+1))
+		}
+	}
+
+	start := -1 // last standstill before the current run, -1 between runs
+	liftStart := -1
+	distance := 0.0 // meters since the launch
+	for i := range s {
+		if start < 0 {
+			if i > 0 && s[i-1] < standstillSpeed && s[i] >= standstillSpeed {
+				start, liftStart, distance = i-1, -1, 0
+			}
+			continue
+		}
+		distance += (t[i] - t[i-1]) / 1000 * (s[i-1] + s[i]) / 2
+		if throttle != nil && throttle[i] == 0 {
+			if liftStart < 0 {
+				liftStart = i
+			}
+		} else {
+			liftStart = -1
+		}
+		lifted := liftStart >= 0 && t[i]-t[liftStart] >= dragLiftMS
+		braked := brake != nil && brake[i] > 0 && distance >= dragLaunchDistance
+		if braked || lifted || s[i] < standstillSpeed {
+			addRun(start, i)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		addRun(start, len(s)-1)
+	}
+	return runs
+}
+
+// Returns the index of the best run: the one that got furthest down the
+// distances on the sheet, and was quickest to the furthest one it reached.
+// Returns -1 if no run reached the first of them.
+func bestDragRun(runs []dragRun) int {
+	for k := len(dragSheetDistances) - 1; k >= 0; k-- {
+		best, bestTime := -1, 0.0
+		for i, run := range runs {
+			if result, ok := run.result(dragSheetDistances[k]); ok && (best < 0 || result.Time < bestTime) {
+				best, bestTime = i, result.Time
+			}
+		}
+		if best >= 0 {
+			return best
+		}
+	}
+	return -1
+}
+
+// Returns the result at the named distance, and false if the car didn't reach it
+func (run dragRun) result(name string) (dragResult, bool) {
+	for _, result := range run.Results {
+		if result.Distance.Name == name {
+			return result, result.Found
+		}
+	}
+	return dragResult{}, false
+}
+
+// The spread of one distance's times over several runs
+type dragSummary struct {
+	Distance dragDistance
+	Runs     int // Runs that reached the distance
+	Best     float64
+	Median   float64
+	StdDev   float64 // Sample standard deviation, 0 for a single run
+}
+
+// Returns the best, median and standard deviation of each distance's times over every run
+func summarizeDragRuns(runs []dragRun, distances []dragDistance) []dragSummary {
+	var summaries []dragSummary
+	for _, distance := range distances {
+		var times []float64
+		for _, run := range runs {
+			if result, ok := run.result(distance.Name); ok {
+				times = append(times, result.Time)
+			}
+		}
+		summary := dragSummary{Distance: distance, Runs: len(times)}
+		if len(times) == 0 {
+			summaries = append(summaries, summary)
+			continue
+		}
+		summary.Median = median(times)
+		summary.Best = times[0]
+		mean := 0.0
+		for _, v := range times {
+			summary.Best = math.Min(summary.Best, v)
+			mean += v / float64(len(times))
+		}
+		if len(times) > 1 {
+			squares := 0.0
+			for _, v := range times {
+				squares += (v - mean) * (v - mean)
+			}
+			summary.StdDev = math.Sqrt(squares / float64(len(times)-1))
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// Times every drag run in a session, prints each run and a summary of them all,
+// and returns the runs and the index of the best one (-1 if none reached 1/8 mile)
+func printDragRuns(session *Session, distances []dragDistance) ([]dragRun, int) {
+	var runs []dragRun
+	for _, data := range splitDragRuns(session) {
+		runs = append(runs, calcDrag(data, distances))
+	}
+	if len(runs) == 0 {
+		fmt.Println("No launches from a standstill found in log.")
+		return nil, -1
+	}
+	best := bestDragRun(runs)
+	for i, run := range runs {
+		note := ""
+		if i == best {
+			note = " (best)"
+		}
+		fmt.Printf("Run %d%s:\n", i+1, note)
+		run.print("  ")
+	}
+	fmt.Printf("\n%d runs:\n", len(runs))
+	for _, summary := range summarizeDragRuns(runs, distances) {
+		if summary.Runs == 0 {
+			continue
+		}
+		fmt.Printf("  %s: best %.3f s, median %.3f s, std dev %.3f s (%d runs)\n",
+			summary.Distance.Name, summary.Best, summary.Median, summary.StdDev, summary.Runs)
+	}
+	return runs, best
+}
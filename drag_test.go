@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTimeAtDistance(t *testing.T) {
+	tests := []struct {
+		name     string
+		distance float64
+		seconds  []float64
+		speeds   []float64
+		want     float64
+		found    bool
+	}{
+		// Distances from the trapezoidal rule: 5 m after 1 s, then 20 m after 2 s
+		{"accelerating", 5, []float64{0, 1, 2}, []float64{0, 10, 20}, 1, true},
+		{"within a data point", 12, []float64{0, 1, 2}, []float64{0, 10, 20}, 1 + (-10+math.Sqrt(100+4*5*7))/10, true},
+		{"steady speed", 15, []float64{0, 1, 2}, []float64{10, 10, 10}, 1.5, true},
+		{"slowing to a stop", 19.999999, []float64{0, 1, 2}, []float64{20, 10, 0}, 2 - math.Sqrt(0.00002)/10, true},
+		// Rounding takes the discriminant just below 0 at the very end of the data point
+		{"stopped at the mark", 1.0 / 60 * (11.0 / 7) / 2, []float64{0, 1.0 / 60}, []float64{11.0 / 7, 0}, 1.0 / 60, true},
+		{"start line", 0, []float64{0, 1}, []float64{0, 10}, 0, true},
+		{"never got there", 100, []float64{0, 1, 2}, []float64{0, 10, 20}, 0, false},
+	}
+	for _, test := range tests {
+		d := make([]float64, len(test.seconds))
+		for i := 1; i < len(d); i++ {
+			d[i] = d[i-1] + (test.seconds[i]-test.seconds[i-1])*(test.speeds[i-1]+test.speeds[i])/2
+		}
+		got, found := timeAtDistance(test.distance, test.seconds, test.speeds, d)
+		if found != test.found || math.IsNaN(got) || math.Abs(got-test.want) > 1e-6 {
+			t.Errorf("%s: timeAtDistance = %.6f (found %v), want %.6f (found %v)", test.name, got, found, test.want, test.found)
+		}
+	}
+}
+
+func TestCalcDrag(t *testing.T) {
+	// Standing still for a second, then accelerating at a steady 8 m/s² for 20 seconds
+	const accel = 8.0
+	session := buildSession([]string{"TimestampMS", "Speed"}, 21*60, func(i int) []float64 {
+		seconds := float64(i) / 60
+		return []float64{seconds * 1000, accel * math.Max(0, seconds-1)}
+	})
+	run := calcDrag(session, defaultDragDistances)
+	for _, result := range run.Results {
+		want := math.Sqrt(2 * result.Distance.Meters / accel)
+		if result.Distance.Name == "1 mile" { // 20 seconds only covers 1600 m
+			want = 0
+		}
+		if math.Abs(result.Time-want) > 0.001 || result.Found != (want > 0) {
+			t.Errorf("calcDrag %s = %.4f s (found %v), want %.4f s", result.Distance.Name, result.Time, result.Found, want)
+		}
+		if !result.Found {
+			continue
+		}
+		// The trap speed is the average over the trap, the speed at its middle in time
+		trap := math.Min(trapLength, result.Distance.Meters)
+		wantTrap := trap / (want - math.Sqrt(2*(result.Distance.Meters-trap)/accel))
+		if math.Abs(result.TrapSpeed-wantTrap) > 0.01 {
+			t.Errorf("calcDrag %s trap speed = %.3f m/s, want %.3f m/s", result.Distance.Name, result.TrapSpeed, wantTrap)
+		}
+	}
+}
+
+func TestDragDistances(t *testing.T) {
+	tests := []struct {
+		extra string
+		want  []string // Names of the extra distances timed
+		err   bool
+	}{
+		{"", nil, false},
+		{"100m, 1km", []string{"100 m", "1 km"}, false},
+		{"402m,1/4 mile,1/4 mi,60 ft,100m,100m", []string{"100 m"}, false},
+		{"100", nil, true},
+		{"100 yd", nil, true},
+		{"0m", nil, true},
+	}
+	for _, test := range tests {
+		distances, err := dragDistances(test.extra)
+		if (err != nil) != test.err {
+			t.Errorf("dragDistances(%q) error %v, want error %v", test.extra, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var extra []string
+		for k, d := range distances {
+			if k > 0 && d.Meters < distances[k-1].Meters {
+				t.Errorf("dragDistances(%q) isn't in order: %v", test.extra, distances)
+			}
+			isDefault := false
+			for _, v := range defaultDragDistances {
+				isDefault = isDefault || v == d
+			}
+			if !isDefault {
+				extra = append(extra, d.Name)
+			}
+		}
+		if len(extra) != len(test.want) || len(distances) != len(defaultDragDistances)+len(test.want) {
+			t.Errorf("dragDistances(%q) added %v, want %v", test.extra, extra, test.want)
+			continue
+		}
+		for k := range extra {
+			if !containsString(test.want, extra[k]) {
+				t.Errorf("dragDistances(%q) added %v, want %v", test.extra, extra, test.want)
+			}
+		}
+	}
+}
+
+func TestSplitDragRuns(t *testing.T) {
+	// Launching at 8 m/s² a second in, then braking from 8 seconds
+	tests := []struct {
+		name      string
+		brakeFrom float64 // Seconds the brake is let go after the launch
+		want      float64 // Seconds the run ends, 0 for no run
+	}{
+		{"rolling launch", 0, 8},
+		{"brake torque launch", 0.25, 8},
+		{"brake held past the launch", 3, 1 + math.Ceil(math.Sqrt(2*dragLaunchDistance/8)*60)/60},
+	}
+	for _, test := range tests {
+		session := buildSession([]string{"TimestampMS", "Speed", "Accel", "Brake"}, 10*60, func(i int) []float64 {
+			seconds := float64(i) / 60
+			brake := 0.0
+			if seconds <= 1+test.brakeFrom || seconds >= 8 {
+				brake = 255
+			}
+			return []float64{seconds * 1000, 8 * math.Max(0, seconds-1), 255, brake}
+		})
+		runs := splitDragRuns(session)
+		if len(runs) != 1 {
+			t.Errorf("%s: %d runs, want 1", test.name, len(runs))
+			continue
+		}
+		times := runs[0].Float("TimestampMS")
+		if end := times[len(times)-1] / 1000; math.Abs(end-test.want) > 1e-9 {
+			t.Errorf("%s: run ends at %.3f s, want %.3f s", test.name, end, test.want)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	dynoBinRPM         = 100 // Width of each RPM bin
+	dynoMinSamples     = 3   // Bins with fewer data points than this are left out of the curve
+	dynoMaxGapBins     = 3   // Torque isn't interpolated across more missing bins than this
+	powerbandThreshold = 0.9 // The powerband is where the engine makes at least this fraction of peak power
+)
+
+// Average power and torque in one RPM bin
+type dynoPoint struct {
+	RPM     float64 // Middle of the bin
+	Power   float64 // hp
+	Torque  float64 // ft-lb
+	Samples int
+}
+
+// A power and torque curve, for one gear or every gear
+type dynoCurve struct {
+	Gear   int // 0 for the curve from every gear
+	Points []dynoPoint
+}
+
+// Returns the name of the curve's gear, as written to the CSV and chart
+func (c dynoCurve) Name() string {
+	if c.Gear == 0 {
+		return "All"
+	}
+	return strconv.Itoa(c.Gear)
+}
+
+// Returns the point with the most power
+func (c dynoCurve) PeakPower() dynoPoint {
+	var peak dynoPoint
+	for _, p := range c.Points {
+		if p.Power > peak.Power {
+			peak = p
+		}
+	}
+	return peak
+}
+
+// Returns the point with the most torque
+func (c dynoCurve) PeakTorque() dynoPoint {
+	var peak dynoPoint
+	for _, p := range c.Points {
+		if p.Torque > peak.Torque {
+			peak = p
+		}
+	}
+	return peak
+}
+
+// Returns the RPM range either side of peak power where the engine makes at
+// least powerbandThreshold of peak power, stopping at the first bin below it
+func (c dynoCurve) Powerband() (float64, float64) {
+	peak := c.PeakPower()
+	limit := peak.Power * powerbandThreshold
+	low, high := peak.RPM, peak.RPM
+	for i, p := range c.Points {
+		if p.RPM != peak.RPM {
+			continue
+		}
+		for j := i; j >= 0 && c.Points[j].Power >= limit; j-- {
+			low = c.Points[j].RPM
+		}
+		for j := i; j < len(c.Points) && c.Points[j].Power >= limit; j++ {
+			high = c.Points[j].RPM
+		}
+		break
+	}
+	return low - dynoBinRPM/2, high + dynoBinRPM/2
+}
+
+// Returns the torque (ft-lb) at an RPM, interpolated between the bins either side
+// of it, and false if the RPM is outside the curve or in a gap in it
+func (c dynoCurve) TorqueAt(rpm float64) (float64, bool) {
+	for i := 1; i < len(c.Points); i++ {
+		p0, p1 := c.Points[i-1], c.Points[i]
+		if rpm >= p0.RPM && rpm <= p1.RPM {
+			if p1.RPM-p0.RPM > dynoMaxGapBins*dynoBinRPM {
+				return 0, false
+			}
+			return p0.Torque + (rpm-p0.RPM)/(p1.RPM-p0.RPM)*(p1.Torque-p0.Torque), true
+		}
+	}
+	return 0, false
+}
+
+// Builds power and torque curves from the wide open throttle data points in a
+// session, averaging every data point in each dynoBinRPM wide CurrentEngineRpm bin.
+// Returns the curve from every gear first (leaving out 1st gear, where launches
+// off the rev limiter read high, unless the car never left it), then one curve per gear.
+// Bins with fewer than minSamples data points are left out.
+// Returns an error if the session has no power data.
+func calcDyno(session *Session, minSamples int) ([]dynoCurve, error) {
+	if err := session.Require("CurrentEngineRpm", "Power", "Torque", "Accel", "Gear"); err != nil {
+		return nil, fmt.Errorf("Dyno curves need power data, which %s doesn't send. %v", session.Format.Game, err)
+	}
+	rpm := session.Float("CurrentEngineRpm")
+	power := session.Float("Power")
+	torque := session.Float("Torque")
+	throttle := session.Float("Accel")
+	gear := session.Int("Gear")
+	clutch := session.Float("Clutch")
+
+	type bin struct {
+		Power, Torque float64
+		Samples       int
+	}
+	gears := make(map[int]map[int]*bin) // gear, then bin number
+	leftFirst := false
+	for i := range rpm {
+		// Gear 0 is reverse, which would otherwise get a curve of its own named like the overall one
+		if throttle[i] < fullThrottle || power[i] <= 0 || rpm[i] <= 0 || gear[i] <= 0 || (clutch != nil && clutch[i] > 0) {
+			continue
+		}
+		if gears[gear[i]] == nil {
+			gears[gear[i]] = make(map[int]*bin)
+		}
+		n := int(rpm[i] / dynoBinRPM)
+		if gears[gear[i]][n] == nil {
+			gears[gear[i]][n] = &bin{}
+		}
+		b := gears[gear[i]][n]
+		b.Power += power[i] * wattsToHP
+		b.Torque += torque[i] * nmToFtLb
+		b.Samples++
+		if gear[i] > 1 {
+			leftFirst = true
+		}
+	}
+	if len(gears) == 0 {
+		return nil, fmt.Errorf("No wide open throttle data found in log")
+	}
+
+	// Every gear is summed into the overall curve before averaging, so gears with more data count for more
+	all := make(map[int]*bin)
+	var gearNumbers []int
+	for g, bins := range gears {
+		gearNumbers = append(gearNumbers, g)
+		if g == 1 && leftFirst {
+			continue
+		}
+		for n, b := range bins {
+			if all[n] == nil {
+				all[n] = &bin{}
+			}
+			all[n].Power += b.Power
+			all[n].Torque += b.Torque
+			all[n].Samples += b.Samples
+		}
+	}
+	sort.Ints(gearNumbers)
+
+	toCurve := func(g int, bins map[int]*bin) dynoCurve {
+		curve := dynoCurve{Gear: g}
+		for n, b := range bins {
+			if b.Samples < minSamples {
+				continue
+			}
+			curve.Points = append(curve.Points, dynoPoint{
+				RPM:     float64(n)*dynoBinRPM + dynoBinRPM/2,
+				Power:   b.Power / float64(b.Samples),
+				Torque:  b.Torque / float64(b.Samples),
+				Samples: b.Samples,
+			})
+		}
+		sort.Slice(curve.Points, func(a, b int) bool {
+			return curve.Points[a].RPM < curve.Points[b].RPM
+		})
+		return curve
+	}
+	curves := []dynoCurve{toCurve(0, all)}
+	for _, g := range gearNumbers {
+		if curve := toCurve(g, gears[g]); len(curve.Points) > 0 {
+			curves = append(curves, curve)
+		}
+	}
+	if len(curves[0].Points) == 0 {
+		return nil, fmt.Errorf("Not enough wide open throttle data in log for a dyno curve")
+	}
+	return curves, nil
+}
+
+// Writes every curve to a CSV file, one row per RPM bin
+func writeDynoCSV(name string, curves []dynoCurve) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("Cannot create '%s': %s", name, err.Error())
+	}
+	defer f.Close()
+	writer := csv.NewWriter(f)
+	writer.Write([]string{"Gear", "RPM", "Power (hp)", "Torque (ft-lb)", "Samples"})
+	for _, curve := range curves {
+		for _, p := range curve.Points {
+			writer.Write([]string{
+				curve.Name(),
+				strconv.FormatFloat(p.RPM, 'f', 0, 64),
+				strconv.FormatFloat(p.Power, 'f', 1, 64),
+				strconv.FormatFloat(p.Torque, 'f', 1, 64),
+				strconv.Itoa(p.Samples),
+			})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Size and margins of the dyno chart in pixels
+const (
+	chartWidth  = 900
+	chartHeight = 540
+	chartLeft   = 70
+	chartRight  = 30
+	chartTop    = 40
+	chartBottom = 60
+)
+
+// Writes the curves as an SVG chart. The curve from every gear is drawn bold,
+// with each gear's curve faint behind it, and the powerband, peak power RPM and
+// peak torque RPM are marked. Power and torque share the same axis, like a
+// chassis dyno sheet, so they cross at 5252 RPM.
+func writeDynoSVG(name string, curves []dynoCurve) error {
+	overall := curves[0]
+	maxRPM, maxValue := 0.0, 0.0
+	for _, curve := range curves {
+		for _, p := range curve.Points {
+			maxRPM = math.Max(maxRPM, p.RPM)
+			maxValue = math.Max(maxValue, math.Max(p.Power, p.Torque))
+		}
+	}
+	maxRPM = math.Ceil(maxRPM/1000) * 1000
+	maxValue = math.Ceil(maxValue/100) * 100
+	plotWidth := float64(chartWidth - chartLeft - chartRight)
+	plotHeight := float64(chartHeight - chartTop - chartBottom)
+	x := func(rpm float64) float64 { return chartLeft + rpm/maxRPM*plotWidth }
+	y := func(v float64) float64 { return chartTop + plotHeight - v/maxValue*plotHeight }
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" font-family=\"sans-serif\" font-size=\"12\">\n", chartWidth, chartHeight)
+	fmt.Fprintf(&svg, "<rect width=\"%d\" height=\"%d\" fill=\"white\"/>\n", chartWidth, chartHeight)
+
+	// Powerband
+	low, high := overall.Powerband()
+	fmt.Fprintf(&svg, "<rect x=\"%.1f\" y=\"%d\" width=\"%.1f\" height=\"%.1f\" fill=\"#fff3c4\"/>\n", x(low), chartTop, x(high)-x(low), plotHeight)
+	fmt.Fprintf(&svg, "<text x=\"%.1f\" y=\"%d\" text-anchor=\"middle\">Powerband %.0f-%.0f rpm</text>\n", (x(low)+x(high))/2, chartTop-8, low, high)
+
+	// Grid and axes
+	for rpm := 0.0; rpm <= maxRPM; rpm += 1000 {
+		fmt.Fprintf(&svg, "<line x1=\"%.1f\" y1=\"%d\" x2=\"%.1f\" y2=\"%.1f\" stroke=\"#ddd\"/>\n", x(rpm), chartTop, x(rpm), chartTop+plotHeight)
+		fmt.Fprintf(&svg, "<text x=\"%.1f\" y=\"%.1f\" text-anchor=\"middle\">%.0f</text>\n", x(rpm), chartTop+plotHeight+18, rpm)
+	}
+	step := maxValue / 10
+	for v := 0.0; v <= maxValue; v += step {
+		fmt.Fprintf(&svg, "<line x1=\"%d\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\" stroke=\"#ddd\"/>\n", chartLeft, y(v), chartLeft+plotWidth, y(v))
+		fmt.Fprintf(&svg, "<text x=\"%d\" y=\"%.1f\" text-anchor=\"end\">%.0f</text>\n", chartLeft-6, y(v)+4, v)
+	}
+	fmt.Fprintf(&svg, "<text x=\"%.1f\" y=\"%d\" text-anchor=\"middle\">Engine RPM</text>\n", chartLeft+plotWidth/2, chartHeight-15)
+	fmt.Fprintf(&svg, "<text x=\"18\" y=\"%.1f\" text-anchor=\"middle\" transform=\"rotate(-90 18 %.1f)\">hp / ft-lb</text>\n", chartTop+plotHeight/2, chartTop+plotHeight/2)
+
+	// Curves, each gear first so the overall curve is drawn on top
+	polyline := func(curve dynoCurve, value func(dynoPoint) float64, colour string, width float64, opacity float64) {
+		var points []string
+		for _, p := range curve.Points {
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x(p.RPM), y(value(p))))
+		}
+		fmt.Fprintf(&svg, "<polyline points=\"%s\" fill=\"none\" stroke=\"%s\" stroke-width=\"%.1f\" opacity=\"%.2f\"/>\n",
+			strings.Join(points, " "), colour, width, opacity)
+	}
+	power := func(p dynoPoint) float64 { return p.Power }
+	torque := func(p dynoPoint) float64 { return p.Torque }
+	for _, curve := range curves[1:] {
+		polyline(curve, power, "#d62728", 1, 0.3)
+		polyline(curve, torque, "#1f77b4", 1, 0.3)
+	}
+	polyline(overall, power, "#d62728", 2.5, 1)
+	polyline(overall, torque, "#1f77b4", 2.5, 1)
+
+	// Peaks
+	peakPower, peakTorque := overall.PeakPower(), overall.PeakTorque()
+	marker := func(p dynoPoint, value float64, colour string, label string) {
+		fmt.Fprintf(&svg, "<line x1=\"%.1f\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\" stroke=\"%s\" stroke-dasharray=\"4 3\"/>\n",
+			x(p.RPM), y(value), x(p.RPM), chartTop+plotHeight, colour)
+		fmt.Fprintf(&svg, "<circle cx=\"%.1f\" cy=\"%.1f\" r=\"4\" fill=\"%s\"/>\n", x(p.RPM), y(value), colour)
+		fmt.Fprintf(&svg, "<text x=\"%.1f\" y=\"%.1f\" text-anchor=\"middle\" fill=\"%s\">%s</text>\n", x(p.RPM), y(value)-10, colour, label)
+	}
+	marker(peakPower, peakPower.Power, "#d62728", fmt.Sprintf("%.0f hp @ %.0f rpm", peakPower.Power, peakPower.RPM))
+	marker(peakTorque, peakTorque.Torque, "#1f77b4", fmt.Sprintf("%.0f ft-lb @ %.0f rpm", peakTorque.Torque, peakTorque.RPM))
+
+	fmt.Fprintf(&svg, "<text x=\"%.1f\" y=\"%d\" text-anchor=\"end\" fill=\"#d62728\">Power (hp)</text>\n", chartLeft+plotWidth-90, chartTop+16)
+	fmt.Fprintf(&svg, "<text x=\"%.1f\" y=\"%d\" text-anchor=\"end\" fill=\"#1f77b4\">Torque (ft-lb)</text>\n", chartLeft+plotWidth, chartTop+16)
+	svg.WriteString("</svg>\n")
+
+	if err := os.WriteFile(name, []byte(svg.String()), 0644); err != nil {
+		return fmt.Errorf("Cannot write '%s': %s", name, err.Error())
+	}
+	return nil
+}
+
+// Builds the dyno curves from a session, writes them to name.csv and name.svg,
+// and prints the peaks and powerband of each
+func printDyno(session *Session, name string) {
+	curves, err := calcDyno(session, dynoMinSamples)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	check(writeDynoCSV(name+".csv", curves))
+	check(writeDynoSVG(name+".svg", curves))
+
+	for _, curve := range curves {
+		peakPower, peakTorque := curve.PeakPower(), curve.PeakTorque()
+		low, high := curve.Powerband()
+		label := "All gears"
+		if curve.Gear != 0 {
+			label = "Gear " + curve.Name()
+		}
+		fmt.Printf("%s: %.0f hp @ %.0f rpm, %.0f ft-lb @ %.0f rpm, powerband %.0f-%.0f rpm (%.0f rpm wide)\n",
+			label, peakPower.Power, peakPower.RPM, peakTorque.Torque, peakTorque.RPM, low, high, high-low)
+	}
+	fmt.Printf("Wrote dyno curves to %s.csv and %s.svg\n", name, name)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCalcDyno(t *testing.T) {
+	// Torque in Nm peaks at 4050 rpm, every data point is in the middle of a bin
+	torque := func(rpm float64) float64 { return 500 - 0.00002*(rpm-4050)*(rpm-4050) }
+	power := func(rpm float64) float64 { return torque(rpm) * rpm * 2 * math.Pi / 60 }
+	var rows [][]float64 // CurrentEngineRpm, Power, Torque, Accel, Gear
+	pull := func(gear float64, from float64, to float64, samples int, throttle float64) {
+		for rpm := from; rpm <= to; rpm += dynoBinRPM {
+			for k := 0; k < samples; k++ {
+				rows = append(rows, []float64{rpm, power(rpm), torque(rpm), throttle, gear})
+			}
+		}
+	}
+	pull(1, 2050, 4950, 3, 255)
+	pull(2, 3050, 6950, 3, 255)
+	pull(2, 7050, 7050, 2, 255) // Too few data points for Dyno Mode, enough for shift points
+	pull(2, 2050, 2950, 3, 100) // Part throttle
+	pull(0, 2050, 2950, 3, 255) // Reversing
+	session := buildSession([]string{"CurrentEngineRpm", "Power", "Torque", "Accel", "Gear"}, len(rows), func(i int) []float64 { return rows[i] })
+
+	tests := []struct {
+		minSamples int
+		gear       int
+		from, to   float64 // RPM of the first and last points
+	}{
+		// The overall curve leaves out 1st gear, since the car left it
+		{dynoMinSamples, 0, 3050, 6950},
+		{dynoMinSamples, 1, 2050, 4950},
+		{dynoMinSamples, 2, 3050, 6950},
+		{2, 0, 3050, 7050},
+		{2, 1, 2050, 4950},
+		{2, 2, 3050, 7050},
+	}
+	for _, test := range tests {
+		curves, err := calcDyno(session, test.minSamples)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(curves) != 3 {
+			t.Fatalf("%d curves, want All, 1 and 2", len(curves))
+		}
+		curve := curves[test.gear]
+		if curve.Gear != test.gear {
+			t.Errorf("curve %d is gear %d", test.gear, curve.Gear)
+		}
+		points := curve.Points
+		if want := int((test.to-test.from)/dynoBinRPM) + 1; len(points) != want || points[0].RPM != test.from || points[len(points)-1].RPM != test.to {
+			t.Errorf("gear %s (min %d samples): %d points from %g to %g rpm, want %d from %g to %g", curve.Name(), test.minSamples, len(points), points[0].RPM, points[len(points)-1].RPM, want, test.from, test.to)
+			continue
+		}
+		for _, p := range points {
+			if math.Abs(p.Torque-torque(p.RPM)*nmToFtLb) > 1e-9 || math.Abs(p.Power-power(p.RPM)*wattsToHP) > 1e-9 {
+				t.Errorf("gear %s at %g rpm: %.3f hp %.3f ft-lb, want %.3f hp %.3f ft-lb", curve.Name(), p.RPM, p.Power, p.Torque, power(p.RPM)*wattsToHP, torque(p.RPM)*nmToFtLb)
+			}
+		}
+		if peak := curve.PeakTorque(); peak.RPM != 4050 {
+			t.Errorf("gear %s peak torque at %g rpm, want 4050", curve.Name(), peak.RPM)
+		}
+	}
+
+	if _, err := calcDyno(buildSession([]string{"CurrentEngineRpm", "Power", "Torque", "Accel", "Gear"}, 2, func(i int) []float64 { return rows[0] }), dynoMinSamples); err == nil {
+		t.Errorf("calcDyno with too little data didn't fail")
+	}
+}
+
+func TestDynoCurve(t *testing.T) {
+	curve := dynoCurve{}
+	for k, p := range []float64{50, 80, 95, 100, 92, 85, 91} {
+		curve.Points = append(curve.Points, dynoPoint{RPM: 1050 + float64(k)*dynoBinRPM, Power: p, Torque: 100 + float64(k)})
+	}
+	curve.Points = append(curve.Points, dynoPoint{RPM: 2050, Power: 60, Torque: 150}) // After a gap of 4 bins
+
+	// At least 90 hp from 1250 to 1450 rpm, stopping at the first bin below it
+	if low, high := curve.Powerband(); low != 1200 || high != 1500 {
+		t.Errorf("Powerband = %g-%g rpm, want 1200-1500", low, high)
+	}
+
+	tests := []struct {
+		rpm   float64
+		want  float64
+		found bool
+	}{
+		{1050, 100, true},
+		{1100, 100.5, true},
+		{1650, 106, true},
+		{1000, 0, false},
+		{1800, 0, false}, // In the gap
+		{2100, 0, false},
+	}
+	for _, test := range tests {
+		got, found := curve.TorqueAt(test.rpm)
+		if found != test.found || math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("TorqueAt(%g) = %g (%v), want %g (%v)", test.rpm, got, found, test.want, test.found)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// A datagram to send, and when to send it relative to the first one
+type emitPacket struct {
+	At   time.Duration
+	Data []byte
+}
+
+// Returns every datagram in a capture file unchanged, timed the same way they were received
+func captureDatagrams(name string) []emitPacket {
+	records, err := readCapture(name)
+	if err != nil && len(records) == 0 {
+		log.Fatalf("Cannot read capture '%s': %s\n", name, err.Error())
+	} else if err != nil {
+		log.Printf("%s, using the first %d datagrams\n", err.Error(), len(records))
+	}
+
+	var packets []emitPacket
+	for _, record := range records {
+		packets = append(packets, emitPacket{At: record.Time.Sub(records[0].Time), Data: record.Data})
+	}
+	return packets
+}
+
+// Encodes every data point of a session as a Data Out datagram in the given
+// packet format, timed by the TimestampMS channel.
+func logDatagrams(session *Session, format *PacketFormat) []emitPacket {
+	if session.Len() < 1 {
+		log.Fatalf("CSV File is empty!")
+	}
+	times := session.Float("TimestampMS")
+
+	var packets []emitPacket
+	var at time.Duration
+	for i, t := range times {
+		// Only move forward in time, so a wrapped TimestampMS or a rewind sends straight away
+		if i > 0 && t > times[i-1] {
+			at += time.Duration((t - times[i-1]) * float64(time.Millisecond))
+		}
+		packet := format.FromFrame(session.Frame(i))
+		packets = append(packets, emitPacket{At: at, Data: packet.Encode()})
+	}
+	return packets
+}
+
+// Sends datagrams to the target address ("host:port") over UDP, at rate times
+// the original speed (2 = twice as fast, 0.5 = half speed).
+func emit(target string, packets []emitPacket, rate float64) {
+	if rate <= 0 {
+		log.Fatalf("Playback rate must be greater than 0")
+	}
+	conn, err := net.Dial("udp", target)
+	if err != nil {
+		log.Fatalf("Unable to send to %s: %v", target, err)
+	}
+	defer conn.Close()
+
+	fmt.Printf("Sending %d packets to %s at %gx speed\n", len(packets), target, rate)
+	start := time.Now()
+	sent := 0
+	for _, packet := range packets {
+		wait := time.Duration(float64(packet.At)/rate) - time.Since(start)
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+		if _, err := conn.Write(packet.Data); err != nil {
+			log.Println(err) // Usually nothing listening on the target port yet, so keep going
+			continue
+		}
+		sent++
+	}
+	fmt.Printf("Sent %d packets in %.1f seconds\n", sent, time.Since(start).Seconds())
+}
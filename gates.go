@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// A timing gate: a line across the track in world coordinates (meters, the same
+// as PositionX/PositionZ), timed when the car drives through it. The order of its
+// ends gives the direction it's driven through: on a map with PositionX to the
+// right and PositionZ up, end 1 is on the car's right.
+type timingGate struct {
+	Name string  `json:"name"` // e.g. "Turn 1 entry" (optional)
+	X1   float64 `json:"x1"`   // The end on the car's right
+	Z1   float64 `json:"z1"`
+	X2   float64 `json:"x2"` // The end on the car's left
+	Z2   float64 `json:"z2"`
+}
+
+// Returns how far along the car's move from (ax, az) to (bx, bz) it went through
+// the gate, from 0 to 1, or false if that move didn't cross it in the gate's
+// direction (so reversing back through it doesn't count). A data point right on
+// the gate counts for the move that ends there, not the one that starts there.
+func (gate timingGate) crossing(ax float64, az float64, bx float64, bz float64) (float64, bool) {
+	dx, dz := bx-ax, bz-az
+	gx, gz := gate.X2-gate.X1, gate.Z2-gate.Z1
+	denom := dx*gz - dz*gx
+	if denom <= 0 { // Moving along the gate, not moving, or going through it the wrong way
+		return 0, false
+	}
+	s := ((gate.X1-ax)*gz - (gate.Z1-az)*gx) / denom // along the car's move
+	u := ((gate.X1-ax)*dz - (gate.Z1-az)*dx) / denom // along the gate
+	if s <= 0 || s > 1 || u < 0 || u > 1 {
+		return 0, false
+	}
+	return s, true
+}
+
+// Returns a gate's name, or its number if it doesn't have one
+func (track trackDefinition) gateName(k int) string {
+	if track.Gates[k].Name != "" {
+		return track.Gates[k].Name
+	}
+	return fmt.Sprintf("Gate %d", k+1)
+}
+
+// One lap's micro-sector times
+type microSectorLap struct {
+	Number  int       // LapNumber + 1 when the car went through the second gate
+	Sectors []float64 // Seconds from each gate to the next, the last back to the first gate
+}
+
+// Times each micro-sector (the part of the lap from one gate to the next, with the
+// last one ending at the first gate) of every lap, interpolating the moment the car
+// went through each gate between the two data points either side of it. Gates have
+// to be driven through in order; going through the first gate again (after a reset
+// or a missed gate) starts over from it. Only laps with every micro-sector are kept.
+func calcMicroSectors(session *Session, track trackDefinition) []microSectorLap {
+	if len(track.Gates) < 2 {
+		return nil
+	}
+	check(session.Require("TimestampMS", "PositionX", "PositionZ", "LapNumber"))
+	t := session.Float("TimestampMS")
+	x := session.Float("PositionX")
+	z := session.Float("PositionZ")
+	l := session.Float("LapNumber")
+
+	var laps []microSectorLap
+	var times []float64 // seconds the car went through each gate this lap
+	number := 0
+	for i := 1; i < len(t); i++ {
+		k := len(times) % len(track.Gates) // next gate expected
+		s, ok := track.Gates[k].crossing(x[i-1], z[i-1], x[i], z[i])
+		if !ok && k != 0 {
+			// A missed gate, start over if the car went through the first one
+			if s, ok = track.Gates[0].crossing(x[i-1], z[i-1], x[i], z[i]); ok {
+				times, k = nil, 0
+			}
+		}
+		if !ok {
+			continue
+		}
+		at := (t[i-1] + s*(t[i]-t[i-1])) / 1000
+		if k == 0 && len(times) > 0 { // Back at the first gate, the lap is done
+			lap := microSectorLap{Number: number}
+			for j := 1; j < len(times); j++ {
+				lap.Sectors = append(lap.Sectors, times[j]-times[j-1])
+			}
+			lap.Sectors = append(lap.Sectors, at-times[len(times)-1])
+			laps = append(laps, lap)
+			times = nil
+		}
+		if k == 1 {
+			number = int(l[i]) + 1
+		}
+		times = append(times, at)
+	}
+	return laps
+}
+
+// Prints each micro-sector's time on a lap (by number, or the quickest lap through
+// the gates if that lap doesn't have every micro-sector), its best time over all
+// laps, and how much time the lap lost there
+func printMicroSectors(laps []microSectorLap, track trackDefinition, number int) {
+	if len(laps) == 0 {
+		fmt.Println("No laps through every timing gate found in log.")
+		return
+	}
+	lap := laps[0]
+	for _, v := range laps {
+		if total(v.Sectors) < total(lap.Sectors) {
+			lap = v
+		}
+	}
+	for _, v := range laps {
+		if v.Number == number {
+			lap = v
+		}
+	}
+	fmt.Printf("Micro-sectors (lap %d against the best of each):\n", lap.Number)
+	lost := 0.0
+	for k := range track.Gates {
+		best := math.Inf(1)
+		bestOn := 0
+		for _, v := range laps {
+			if v.Sectors[k] < best {
+				best, bestOn = v.Sectors[k], v.Number
+			}
+		}
+		lost += lap.Sectors[k] - best
+		fmt.Printf("  %s to %s: %.3f s  best %.3f s (lap %d)  +%.3f s\n",
+			track.gateName(k), track.gateName((k+1)%len(track.Gates)), lap.Sectors[k], best, bestOn, lap.Sectors[k]-best)
+	}
+	fmt.Printf("  Lost %.3f s to the best micro-sectors\n", lost)
+}
+
+// Returns the sum of some times
+func total(times []float64) float64 {
+	sum := 0.0
+	for _, v := range times {
+		sum += v
+	}
+	return sum
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGateCrossing(t *testing.T) {
+	gate := timingGate{X1: 0, Z1: -1, X2: 0, Z2: 1} // Driven through going +X
+	tests := []struct {
+		name           string
+		ax, az, bx, bz float64
+		want           float64
+		ok             bool
+	}{
+		{"straight through", -1, 0, 1, 0, 0.5, true},
+		{"at an angle", -1, -1, 3, 1, 0.25, true},
+		{"ends on the gate", -1, 0, 0, 0, 1, true},
+		{"starts on the gate", 0, 0, 1, 0, 0, false},
+		{"reversing through", 1, 0, -1, 0, 0, false},
+		{"past the end", -1, 2, 1, 2, 0, false},
+		{"short of the gate", -2, 0, -1, 0, 0, false},
+		{"along the gate", 0, -1, 0, 1, 0, false},
+		{"not moving", -1, 0, -1, 0, 0, false},
+	}
+	for _, test := range tests {
+		got, ok := gate.crossing(test.ax, test.az, test.bx, test.bz)
+		if ok != test.ok || math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: crossing = %.3f (%v), want %.3f (%v)", test.name, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestCalcMicroSectors(t *testing.T) {
+	// Driving +X from 0 to 90 m each lap at 100 m/s, then jumping back to 0 (back
+	// through both gates the wrong way)
+	track := trackDefinition{Gates: []timingGate{{X1: 25, Z1: -1, X2: 25, Z2: 1}, {X1: 55, Z1: -1, X2: 55, Z2: 1}}}
+	session := buildSession([]string{"TimestampMS", "PositionX", "PositionZ", "LapNumber"}, 30, func(i int) []float64 {
+		return []float64{float64(i) * 100, float64(i%10) * 10, 0, float64(i / 10)}
+	})
+	laps := calcMicroSectors(session, track)
+	if len(laps) != 2 {
+		t.Fatalf("%d laps %v, want 2", len(laps), laps)
+	}
+	for k, lap := range laps {
+		if lap.Number != k+1 || len(lap.Sectors) != 2 || math.Abs(lap.Sectors[0]-0.3) > 1e-9 || math.Abs(lap.Sectors[1]-0.7) > 1e-9 {
+			t.Errorf("lap %d = %+v, want lap %d of [0.3 0.7]", k, lap, k+1)
+		}
+	}
+}
+
+func TestCalcLapsWithGates(t *testing.T) {
+	// Radial lines across testRace's circle, halfway between two data points, outside end first
+	gate := func(distance float64) timingGate {
+		angle := 2 * math.Pi * distance / 1000
+		return timingGate{
+			X1: (raceRadius + 10) * math.Cos(angle), Z1: (raceRadius + 10) * math.Sin(angle),
+			X2: (raceRadius - 10) * math.Cos(angle), Z2: (raceRadius - 10) * math.Sin(angle),
+		}
+	}
+	track := trackDefinition{Name: "Test", Length: 1000, Gates: []timingGate{gate(402.5), gate(702.5)}}
+	laps := calcLaps(testRace, track)
+	if len(laps) != 2 {
+		t.Fatalf("%d laps, want 2", len(laps))
+	}
+	want := []float64{8.05, 6, 5.95}
+	for _, lap := range laps {
+		if !lap.Valid || len(lap.Sectors) != len(want) {
+			t.Errorf("lap %d = %+v, want a valid lap with sectors %v", lap.Number, lap, want)
+			continue
+		}
+		for k := range want {
+			if math.Abs(lap.Sectors[k]-want[k]) > 1e-6 {
+				t.Errorf("lap %d sectors = %v, want %v", lap.Number, lap.Sectors, want)
+				break
+			}
+		}
+	}
+
+	// The wrong way round, the gates are never timed
+	track.Gates = []timingGate{gate(402.5), {X1: track.Gates[1].X2, Z1: track.Gates[1].Z2, X2: track.Gates[1].X1, Z2: track.Gates[1].Z1}}
+	for _, lap := range calcLaps(testRace, track) {
+		if lap.Valid || lap.Sectors[1] != 0 {
+			t.Errorf("lap %d through a backwards gate = %+v, want it to miss a sector", lap.Number, lap)
+		}
+	}
+}
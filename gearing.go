@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	gripSlip        = 0.1  // A TireSlipRatio below this means the tire is rolling, not spinning or skidding
+	gearingMinSpeed = 5.0  // m/s, below this the wheel speeds are too coarse to work out ratios from
+	minRatioSamples = 30   // Half a second in gear with the tires gripping
+	shiftStepRPM    = 10.0 // RPM step when searching for the shift point
+	shiftMinSamples = 2    // Fewest data points in a torque curve bin, less than Dyno Mode needs since 1st gear often revs through a bin in 2
+	radPerSecToRPM  = 60 / (2 * math.Pi)
+)
+
+// Wheel rotation speed and slip ratio channels of the front and rear wheels
+var (
+	frontWheels = [][2]string{
+		{"WheelRotationSpeedFrontLeft", "TireSlipRatioFrontLeft"},
+		{"WheelRotationSpeedFrontRight", "TireSlipRatioFrontRight"},
+	}
+	rearWheels = [][2]string{
+		{"WheelRotationSpeedRearLeft", "TireSlipRatioRearLeft"},
+		{"WheelRotationSpeedRearRight", "TireSlipRatioRearRight"},
+	}
+)
+
+// The car's gearing worked out from the log
+type gearing struct {
+	TireRadius float64         // Meters
+	Ratios     map[int]float64 // Overall ratio of each gear (gearbox × final drive), engine turns per wheel turn
+	Gears      []int           // Gears with a ratio, in order
+}
+
+// An upshift the tool recommends, and how the driver's shifts compared
+type shiftPoint struct {
+	From, To     int
+	RPM          float64 // Engine RPM to shift at for the most wheel torque
+	AtRedline    bool    // The next gear never gives more wheel torque, so hold the gear to the redline
+	Found        bool    // False if the torque curve doesn't cover the RPMs either side of the shift
+	ActualShifts int     // Full throttle upshifts the driver made from this gear
+	ActualRPM    float64 // Average RPM the driver shifted at
+}
+
+// Returns the median of a list of values (0 if it's empty)
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// Returns the wheels driven by the car's drivetrain, and the ones that aren't
+// (nil for AWD)
+func drivenWheels(drivetrain int) ([][2]string, [][2]string) {
+	switch drivetrain {
+	case 0: // FWD
+		return frontWheels, rearWheels
+	case 1: // RWD
+		return rearWheels, frontWheels
+	}
+	return append(append([][2]string(nil), frontWheels...), rearWheels...), nil
+}
+
+// Works out the tire radius and each gear's overall ratio from the data points
+// where the tires are gripping (slip ratio below gripSlip) and the clutch is out.
+// The tire radius is Speed divided by wheel rotation speed, using the undriven
+// wheels when there are any since they never spin. Each gear's ratio is engine
+// speed divided by the driven wheels' rotation speed. Medians are used so
+// shifts, bumps and wheelspin the slip ratio misses don't pull the results.
+func calcGearing(session *Session) (gearing, error) {
+	result := gearing{Ratios: make(map[int]float64)}
+	if err := session.Require("CurrentEngineRpm", "Gear", "Speed", "DrivetrainType"); err != nil {
+		return result, fmt.Errorf("Gearing needs engine and gear data, which %s doesn't send. %v", session.Format.Game, err)
+	}
+	driven, undriven := drivenWheels(session.Frame(0).Int("DrivetrainType"))
+	for _, wheel := range append(append([][2]string(nil), driven...), undriven...) {
+		if err := session.Require(wheel[0], wheel[1]); err != nil {
+			return result, err
+		}
+	}
+	rpm := session.Float("CurrentEngineRpm")
+	gear := session.Int("Gear")
+	speeds := session.Float("Speed")
+	clutch := session.Float("Clutch")
+
+	// Average rotation speed of a set of wheels at a data point, false if any of them are slipping
+	wheelSpeed := func(wheels [][2]string, i int) (float64, bool) {
+		total := 0.0
+		for _, wheel := range wheels {
+			if math.Abs(session.Float(wheel[1])[i]) >= gripSlip {
+				return 0, false
+			}
+			total += session.Float(wheel[0])[i]
+		}
+		return total / float64(len(wheels)), true
+	}
+
+	radiusWheels := undriven
+	if radiusWheels == nil {
+		radiusWheels = driven
+	}
+	var radii []float64
+	ratios := make(map[int][]float64)
+	for i := range rpm {
+		if speeds[i] < gearingMinSpeed || (clutch != nil && clutch[i] > 0) {
+			continue
+		}
+		if omega, ok := wheelSpeed(radiusWheels, i); ok && omega > 0 {
+			radii = append(radii, speeds[i]/omega)
+		}
+		if omega, ok := wheelSpeed(driven, i); ok && omega > 0 && gear[i] > 0 && rpm[i] > 0 {
+			ratios[gear[i]] = append(ratios[gear[i]], rpm[i]/radPerSecToRPM/omega)
+		}
+	}
+	if len(radii) < minRatioSamples {
+		return result, fmt.Errorf("Not enough data with the tires gripping to work out the tire size")
+	}
+	result.TireRadius = median(radii)
+	for g, values := range ratios {
+		if len(values) < minRatioSamples {
+			continue
+		}
+		result.Ratios[g] = median(values)
+		result.Gears = append(result.Gears, g)
+	}
+	sort.Ints(result.Gears)
+	if len(result.Gears) == 0 {
+		return result, fmt.Errorf("Not enough data in any gear to work out the gear ratios")
+	}
+	return result, nil
+}
+
+// Returns the upshift RPM for each pair of gears next to each other that gives
+// the most torque at the wheels, using the engine's torque curve. For each RPM
+// in the lower gear, the engine would be at RPM × (next ratio / this ratio) after
+// the shift, and the best shift is the first RPM where the next gear gives more
+// wheel torque (engine torque × overall ratio). If that never happens before the
+// end of the torque curve, the best shift is at the redline.
+// The RPM the driver actually shifted at is the last full throttle data point
+// before each upshift.
+func calcShiftPoints(session *Session, gears gearing, curve dynoCurve) []shiftPoint {
+	redline := curve.Points[len(curve.Points)-1].RPM
+	if maxRPM := session.Float("EngineMaxRpm"); maxRPM != nil && maxRPM[0] > 0 {
+		redline = math.Min(redline, maxRPM[0])
+	}
+
+	var shifts []shiftPoint
+	for k := 0; k+1 < len(gears.Gears); k++ {
+		from, to := gears.Gears[k], gears.Gears[k+1]
+		if to != from+1 {
+			continue // A gear in between wasn't used enough to know its ratio
+		}
+		ratio, next := gears.Ratios[from], gears.Ratios[to]
+		shift := shiftPoint{From: from, To: to, RPM: redline, AtRedline: true}
+		for rpm := curve.PeakTorque().RPM; rpm <= redline; rpm += shiftStepRPM {
+			torque, ok := curve.TorqueAt(rpm)
+			nextTorque, nextOk := curve.TorqueAt(rpm * next / ratio)
+			if !ok || !nextOk {
+				continue
+			}
+			shift.Found = true
+			if nextTorque*next > torque*ratio {
+				shift.RPM, shift.AtRedline = rpm, false
+				break
+			}
+		}
+		shifts = append(shifts, shift)
+	}
+
+	// The driver's full throttle upshifts
+	if session.Require("Accel", "Gear", "CurrentEngineRpm") != nil {
+		return shifts
+	}
+	rpm := session.Float("CurrentEngineRpm")
+	gear := session.Int("Gear")
+	throttle := session.Float("Accel")
+	totals := make(map[int]float64)
+	counts := make(map[int]int)
+	for i := 1; i < len(gear); i++ {
+		if gear[i] != gear[i-1]+1 || gear[i-1] == 0 {
+			continue
+		}
+		// The clutch goes in (and the throttle may lift) for a few data points before the gear changes
+		last := i - 1
+		for last > 0 && gear[last-1] == gear[i-1] && throttle[last] < fullThrottle {
+			last--
+		}
+		if throttle[last] < fullThrottle {
+			continue
+		}
+		totals[gear[i-1]] += rpm[last]
+		counts[gear[i-1]]++
+	}
+	for k := range shifts {
+		if n := counts[shifts[k].From]; n > 0 {
+			shifts[k].ActualShifts = n
+			shifts[k].ActualRPM = totals[shifts[k].From] / float64(n)
+		}
+	}
+	return shifts
+}
+
+// Returns a torque curve covering every RPM the engine reached at wide open
+// throttle. The curve from every gear leaves out 1st gear, but after an upshift
+// the engine is often at an RPM it only reached in 1st, so those bins are filled
+// in from each gear's curve (weighted by their data points).
+func engineCurve(curves []dynoCurve) dynoCurve {
+	overall := curves[0]
+	known := make(map[float64]bool)
+	for _, p := range overall.Points {
+		known[p.RPM] = true
+	}
+	extra := make(map[float64]*dynoPoint)
+	for _, curve := range curves[1:] {
+		for _, p := range curve.Points {
+			if known[p.RPM] {
+				continue
+			}
+			if extra[p.RPM] == nil {
+				extra[p.RPM] = &dynoPoint{RPM: p.RPM}
+			}
+			e := extra[p.RPM]
+			e.Torque = (e.Torque*float64(e.Samples) + p.Torque*float64(p.Samples)) / float64(e.Samples+p.Samples)
+			e.Power = (e.Power*float64(e.Samples) + p.Power*float64(p.Samples)) / float64(e.Samples+p.Samples)
+			e.Samples += p.Samples
+		}
+	}
+	merged := dynoCurve{Points: append([]dynoPoint(nil), overall.Points...)}
+	for _, p := range extra {
+		merged.Points = append(merged.Points, *p)
+	}
+	sort.Slice(merged.Points, func(a, b int) bool {
+		return merged.Points[a].RPM < merged.Points[b].RPM
+	})
+	return merged
+}
+
+// Prints the tire size, each gear's ratio, the best upshift RPM for each gear and
+// how far the driver's shifts were from it
+func printGearing(session *Session) {
+	gears, err := calcGearing(session)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Tire radius: %.3f m (%.1f in diameter)\n", gears.TireRadius, gears.TireRadius*2*metersToFeet*12)
+	fmt.Println("Overall gear ratios (gearbox × final drive):")
+	for _, g := range gears.Gears {
+		// Speed at 1000 rpm is the usual way to compare gearing between cars
+		mph := 1000 / radPerSecToRPM / gears.Ratios[g] * gears.TireRadius * speedUnits["mph"]
+		fmt.Printf("  Gear %d: %.3f (%.1f mph per 1000 rpm)\n", g, gears.Ratios[g], mph)
+	}
+
+	curves, err := calcDyno(session, shiftMinSamples)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("Shift points for the most wheel torque:")
+	for _, shift := range calcShiftPoints(session, gears, engineCurve(curves)) {
+		if !shift.Found {
+			fmt.Printf("  %d-%d: not enough full throttle data to compare the gears\n", shift.From, shift.To)
+			continue
+		}
+		line := fmt.Sprintf("  %d-%d: %.0f rpm", shift.From, shift.To, shift.RPM)
+		if shift.AtRedline {
+			line += " (redline)"
+		}
+		if shift.ActualShifts > 0 {
+			line += fmt.Sprintf(", you shifted at %.0f rpm (%+.0f rpm, %d shifts)",
+				shift.ActualRPM, shift.ActualRPM-shift.RPM, shift.ActualShifts)
+		} else {
+			line += ", no full throttle shifts in log"
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGearing(t *testing.T) {
+	// A RWD car with 0.33 m tires pulling through 1st (12:1 overall) and 2nd (8:1),
+	// with a burst of wheelspin at the start and a full throttle upshift at 5200 rpm
+	const radius = 0.33
+	ratios := map[int]float64{1: 12, 2: 8}
+	columns := []string{"CurrentEngineRpm", "Gear", "Speed", "DrivetrainType", "Accel", "Clutch",
+		"WheelRotationSpeedFrontLeft", "WheelRotationSpeedFrontRight", "WheelRotationSpeedRearLeft", "WheelRotationSpeedRearRight",
+		"TireSlipRatioFrontLeft", "TireSlipRatioFrontRight", "TireSlipRatioRearLeft", "TireSlipRatioRearRight"}
+	var rows [][]float64
+	speed := 6.0
+	gear := 1
+	for i := 0; i < 240; i++ {
+		omega := speed / radius
+		rearOmega, rearSlip := omega, 0.0
+		if i < 10 {
+			rearOmega, rearSlip = omega*1.5, 0.5
+		}
+		rpm := ratios[gear] * rearOmega * radPerSecToRPM
+		throttle, clutch := 255.0, 0.0
+		if gear == 1 && rpm >= 5200 {
+			throttle, clutch = 0, 255 // Changing gear
+		}
+		rows = append(rows, []float64{rpm, float64(gear), speed, 1, throttle, clutch, omega, omega, rearOmega, rearOmega, 0, 0, rearSlip, rearSlip})
+		if clutch > 0 {
+			gear = 2
+		}
+		speed += 0.1
+	}
+	session := buildSession(columns, len(rows), func(i int) []float64 { return rows[i] })
+
+	gears, err := calcGearing(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(gears.TireRadius-radius) > 1e-9 {
+		t.Errorf("TireRadius = %.4f m, want %.4f m", gears.TireRadius, radius)
+	}
+	if len(gears.Gears) != 2 {
+		t.Fatalf("Gears = %v, want [1 2]", gears.Gears)
+	}
+	for g, want := range ratios {
+		if math.Abs(gears.Ratios[g]-want) > 1e-9 {
+			t.Errorf("gear %d ratio = %.4f, want %.4f", g, gears.Ratios[g], want)
+		}
+	}
+
+	// Flat torque to 4000 rpm, then falling 0.1 Nm per rpm. 2nd gives more wheel
+	// torque once 12 × (800 - 0.1 × rpm) < 8 × 400, above 5333 rpm.
+	var curve dynoCurve
+	for rpm := 1050.0; rpm <= 7950; rpm += dynoBinRPM {
+		curve.Points = append(curve.Points, dynoPoint{RPM: rpm, Torque: math.Min(400, 800-0.1*rpm), Samples: 3})
+	}
+	shifts := calcShiftPoints(session, gears, curve)
+	if len(shifts) != 1 {
+		t.Fatalf("%d shift points, want 1", len(shifts))
+	}
+	shift := shifts[0]
+	if !shift.Found || shift.AtRedline || shift.RPM != 5340 {
+		t.Errorf("1-2 shift at %.0f rpm (found %v, redline %v), want 5340 rpm", shift.RPM, shift.Found, shift.AtRedline)
+	}
+	if last := rows[len(rows)-1]; shift.ActualShifts != 1 || shift.ActualRPM < 5200-ratios[1]*0.1/radius*radPerSecToRPM || shift.ActualRPM >= 5200 || last[1] != 2 {
+		t.Errorf("driver shifted %d times at %.0f rpm, want once just below 5200 rpm", shift.ActualShifts, shift.ActualRPM)
+	}
+
+	// With flat torque, 2nd never gives more wheel torque than 1st
+	for k := range curve.Points {
+		curve.Points[k].Torque = 400
+	}
+	if shifts := calcShiftPoints(session, gears, curve); !shifts[0].AtRedline || shifts[0].RPM != 7950 {
+		t.Errorf("1-2 shift with flat torque at %.0f rpm (redline %v), want the 7950 rpm redline", shifts[0].RPM, shifts[0].AtRedline)
+	}
+}
+
+func TestEngineCurve(t *testing.T) {
+	curves := []dynoCurve{
+		{Gear: 0, Points: []dynoPoint{{RPM: 3050, Torque: 300, Samples: 3}, {RPM: 3150, Torque: 310, Samples: 3}}},
+		{Gear: 1, Points: []dynoPoint{{RPM: 2050, Torque: 100, Samples: 3}, {RPM: 3050, Torque: 999, Samples: 3}}},
+		{Gear: 2, Points: []dynoPoint{{RPM: 2050, Torque: 200, Samples: 1}}},
+	}
+	merged := engineCurve(curves)
+	want := []dynoPoint{{RPM: 2050, Torque: 125, Samples: 4}, {RPM: 3050, Torque: 300, Samples: 3}, {RPM: 3150, Torque: 310, Samples: 3}}
+	if len(merged.Points) != len(want) {
+		t.Fatalf("engineCurve = %v, want %v", merged.Points, want)
+	}
+	for k, p := range merged.Points {
+		if p.RPM != want[k].RPM || math.Abs(p.Torque-want[k].Torque) > 1e-9 || p.Samples != want[k].Samples {
+			t.Errorf("engineCurve point %d = %+v, want %+v", k, p, want[k])
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Speed unit conversions from meters/sec (what the game sends)
+var speedUnits = map[string]float64{
+	"mph":  2.237,
+	"km/h": 3.6,
+}
+
+// A speed range to time the car over. From < To times acceleration (e.g. 0-60 mph),
+// From > To times deceleration (e.g. 60-0 mph).
+type speedInterval struct {
+	Name string  `json:"name"` // Name of the result, defaults to e.g. "0-60 mph"
+	From float64 `json:"from"`
+	To   float64 `json:"to"`
+	Unit string  `json:"unit"` // mph (default) or km/h
+	// Stat line column the result is written to, e.g. "S" (one of statLineIntervalColumns).
+	// Intervals without one are only printed.
+	Column string `json:"column,omitempty"`
+}
+
+// Speed intervals to time, depending on the car's class
+type intervalConfig struct {
+	Intervals []speedInterval  `json:"intervals"` // Timed for every class
+	Classes   []classIntervals `json:"classes"`
+}
+
+// Extra intervals for a group of car classes, e.g. 60-150 and 100-200 mph for the fastest cars
+type classIntervals struct {
+	Games     []string        `json:"games"`   // Packet formats (games) this applies to, or empty for all of them
+	Letters   []string        `json:"letters"` // Class letters, e.g. "S1" (see packetFormatSources)
+	Intervals []speedInterval `json:"intervals"`
+}
+
+// The included intervals.json, timed when there's no intervals file. It matches
+// the stats spreadsheet: Forza Motorsport (2023) splits the same PI range into more
+// classes, so its letters are grouped by the PI range of the Forza Horizon classes.
+//
+//go:embed intervals.json
+var defaultIntervalsFile []byte
+
+// Returns the intervals in the included intervals.json
+func defaultIntervals() (intervalConfig, error) {
+	return parseIntervals("intervals.json (included)", defaultIntervalsFile)
+}
+
+// Loads the speed intervals to time from a JSON file, either an intervalConfig
+// or a plain array of intervals to time for every class.
+// Uses the included intervals.json if the file doesn't exist.
+func loadIntervals(name string) (intervalConfig, error) {
+	b, err := ioutil.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return defaultIntervals()
+	} else if err != nil {
+		return intervalConfig{}, fmt.Errorf("Cannot read '%s': %s", name, err.Error())
+	}
+	return parseIntervals(name, b)
+}
+
+// Parses an intervals file
+func parseIntervals(name string, b []byte) (intervalConfig, error) {
+	var config intervalConfig
+	var err error
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(b, &config.Intervals)
+	} else {
+		err = json.Unmarshal(b, &config)
+	}
+	if err != nil {
+		return intervalConfig{}, fmt.Errorf("Cannot parse '%s': %s", name, err.Error())
+	}
+	config, err = config.normalize()
+	if err != nil {
+		return intervalConfig{}, fmt.Errorf("%s: %v", name, err)
+	}
+	return config, nil
+}
+
+// Fills in the default unit and name of every interval, and checks they can be timed
+func (config intervalConfig) normalize() (intervalConfig, error) {
+	intervals, err := normalizeIntervals(config.Intervals)
+	if err != nil {
+		return intervalConfig{}, err
+	}
+	if err := checkColumns(intervals); err != nil {
+		return intervalConfig{}, err
+	}
+	normalized := intervalConfig{Intervals: intervals}
+	for _, class := range config.Classes {
+		if len(class.Letters) == 0 {
+			return intervalConfig{}, errors.New("Class intervals need at least one class letter")
+		}
+		for _, game := range class.Games {
+			if _, err := getPacketFormat(game); err != nil {
+				return intervalConfig{}, err
+			}
+		}
+		class.Intervals, err = normalizeIntervals(class.Intervals)
+		if err != nil {
+			return intervalConfig{}, err
+		}
+		class.Intervals = assignClassColumns(intervals, class.Intervals)
+		if err := checkColumns(append(append([]speedInterval(nil), intervals...), class.Intervals...)); err != nil {
+			return intervalConfig{}, err
+		}
+		normalized.Classes = append(normalized.Classes, class)
+	}
+	return normalized, nil
+}
+
+// Returns the intervals to time for a car class in the given packet format: the
+// intervals for every class, then those of the first class group that matches.
+// Intervals already listed for every class aren't repeated.
+func (config intervalConfig) forClass(format *PacketFormat, letter string) []speedInterval {
+	intervals := config.Intervals
+	for _, class := range config.Classes {
+		if (len(class.Games) > 0 && !containsString(class.Games, format.Name)) || !containsString(class.Letters, letter) {
+			continue
+		}
+		intervals = append([]speedInterval(nil), intervals...)
+		for _, interval := range class.Intervals {
+			if !containsInterval(intervals, interval.Name) {
+				intervals = append(intervals, interval)
+			}
+		}
+		break
+	}
+	return intervals
+}
+
+// Returns true if the list contains the string
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the list contains an interval with the given name
+func containsInterval(intervals []speedInterval, name string) bool {
+	for _, interval := range intervals {
+		if interval.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Gives class intervals without a column the next free class column (see
+// statLineClassColumns) in the order they're listed, like 25-75 then 50-100 mph
+// for D and C class. Intervals timed for every class take priority, and any left
+// over when the columns run out are only printed.
+func assignClassColumns(every []speedInterval, intervals []speedInterval) []speedInterval {
+	used := make(map[string]bool)
+	for _, interval := range append(append([]speedInterval(nil), every...), intervals...) {
+		used[interval.Column] = true
+	}
+	assigned := append([]speedInterval(nil), intervals...)
+	next := 0
+	for k, interval := range assigned {
+		if interval.Column != "" || containsInterval(every, interval.Name) {
+			continue
+		}
+		for next < len(statLineClassColumns) && used[statLineClassColumns[next]] {
+			next++
+		}
+		if next == len(statLineClassColumns) {
+			break
+		}
+		assigned[k].Column = statLineClassColumns[next]
+		used[statLineClassColumns[next]] = true
+	}
+	return assigned
+}
+
+// Checks intervals timed for the same car are only written to the stat line's
+// interval columns, and never two to the same column. An interval for every
+// class is allowed to share a column with a class interval of the same name,
+// since it replaces it.
+func checkColumns(intervals []speedInterval) error {
+	used := make(map[string]string) // column -> interval name
+	for _, interval := range intervals {
+		if interval.Column == "" {
+			continue
+		}
+		if !containsString(statLineIntervalColumns, interval.Column) {
+			return fmt.Errorf("Interval '%s' column %s isn't an interval column on the stat line (%s)", interval.Name, interval.Column, strings.Join(statLineIntervalColumns, ", "))
+		}
+		if name, isPresent := used[interval.Column]; isPresent && name != interval.Name {
+			return fmt.Errorf("Intervals '%s' and '%s' are both written to column %s", name, interval.Name, interval.Column)
+		}
+		used[interval.Column] = interval.Name
+	}
+	return nil
+}
+
+// Fills in the default unit and name of each interval, and checks they can be timed
+func normalizeIntervals(intervals []speedInterval) ([]speedInterval, error) {
+	var normalized []speedInterval
+	names := make(map[string]bool)
+	for _, interval := range intervals {
+		if interval.Unit == "" {
+			interval.Unit = "mph"
+		}
+		if _, isPresent := speedUnits[interval.Unit]; !isPresent {
+			return nil, fmt.Errorf("Unknown speed unit '%s' (expected mph or km/h)", interval.Unit)
+		}
+		if interval.Name == "" {
+			interval.Name = fmt.Sprintf("%g-%g %s", interval.From, interval.To, interval.Unit)
+		}
+		if interval.From == interval.To || interval.From < 0 || interval.To < 0 {
+			return nil, fmt.Errorf("Interval '%s' needs two different speeds of 0 or more", interval.Name)
+		}
+		if names[interval.Name] {
+			return nil, fmt.Errorf("Interval '%s' is listed more than once", interval.Name)
+		}
+		names[interval.Name] = true
+		normalized = append(normalized, interval)
+	}
+	return normalized, nil
+}
+
+// How speed intervals are timed
+type intervalTiming struct {
+	Config    intervalConfig
+	Smoothing int // Data points in the moving average applied to Speed first (0 for none)
+}
+
+// Stat results by name, in the order they were added
+type statResults struct {
+	Names         []string
+	Values        map[string]string
+	Uncertainties map[string]float64 // Uncertainty of timed results in seconds
+}
+
+func newStatResults() *statResults {
+	return &statResults{Values: make(map[string]string), Uncertainties: make(map[string]float64)}
+}
+
+// Adds (or replaces) a result
+func (r *statResults) add(name string, value string) {
+	if _, isPresent := r.Values[name]; !isPresent {
+		r.Names = append(r.Names, name)
+	}
+	r.Values[name] = value
+}
+
+// Returns the named result, or "" if it wasn't calculated
+func (r *statResults) Get(name string) string {
+	if r == nil {
+		return ""
+	}
+	return r.Values[name]
+}
+
+// Returns the named result with its uncertainty if it has one (e.g. "3.512 ± 0.0040")
+func (r *statResults) Format(name string) string {
+	u, isPresent := r.Uncertainties[name]
+	if !isPresent {
+		return r.Get(name)
+	}
+	return fmt.Sprintf("%s ± %.4f", r.Get(name), u)
+}
+
+// Times every interval and adds the results, "Failed!" when the car never
+// covered that speed range. Times are in seconds, speeds in meters/sec.
+func timeIntervals(results *statResults, intervals []speedInterval, smoothing int, t []float64, speeds []float64) {
+	speeds = smoothSpeeds(speeds, smoothing)
+	converted := make(map[string][]float64) // speeds in each unit used
+	for _, interval := range intervals {
+		s, isPresent := converted[interval.Unit]
+		if !isPresent {
+			s = make([]float64, len(speeds))
+			for i, v := range speeds {
+				s[i] = v * speedUnits[interval.Unit]
+			}
+			converted[interval.Unit] = s
+		}
+
+		elapsed, uncertainty, err := getTimeBetween(interval.From, interval.To, t, s)
+		if err != nil {
+			results.add(interval.Name, "Failed!")
+		} else {
+			results.add(interval.Name, strconv.FormatFloat(elapsed, 'f', 3, 32))
+			results.Uncertainties[interval.Name] = uncertainty
+		}
+	}
+}
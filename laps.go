@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	lapStartTolerance    = 1.0  // Seconds, a lap whose first data point has more CurrentLap than this started before logging did
+	lapDistanceTolerance = 0.02 // Fraction of the track length a lap's distance can be off by (cut corners, rewinds, resets)
+)
+
+// One lap of a race
+type lapRecord struct {
+	Number   int       `json:"lap"`              // LapNumber + 1, as the game shows it
+	Time     float64   `json:"time"`             // Seconds
+	Sectors  []float64 `json:"sectors"`          // Seconds, 0 for sectors the lap didn't cross
+	TopSpeed float64   `json:"topSpeed"`         // mph
+	MinSpeed float64   `json:"minSpeed"`         // mph
+	AvgSpeed float64   `json:"avgSpeed"`         // mph
+	FuelUsed float64   `json:"fuelUsed"`         // Percent of a full tank
+	Valid    bool      `json:"valid"`            // False if the lap wasn't a full, clean lap of the track
+	Reason   string    `json:"reason,omitempty"` // Why the lap isn't valid
+}
+
+// Returns true if the lap crossed the end of every sector
+func (lap lapRecord) hasEverySector() bool {
+	for _, v := range lap.Sectors[:len(lap.Sectors)-1] {
+		if v <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns only the data from the last track driven, if the log has the track (only
+// Forza Motorsport (2023) sends TrackOrdinal), so laps from an earlier race don't get mixed in
+func lastTrackData(session *Session) *Session {
+	if !session.Has("TrackOrdinal") {
+		return session
+	}
+	tracks := session.Int("TrackOrdinal")
+	track := tracks[len(tracks)-1]
+	var onTrack []int
+	for i, v := range tracks {
+		if v == track {
+			onTrack = append(onTrack, i)
+		}
+	}
+	return session.Select(onTrack)
+}
+
+// Splits a race into laps at each LapNumber change, timing each lap with the
+// LastLap the game reports once it's over and each sector with CurrentLap when
+// the car's distance into the lap passes the end of the sector, or when it goes
+// through the sector's timing gate (interpolated between the data points either
+// side) if the track has gates. The last sector ends at the finish line. A lap still running at the end of the log counts if the
+// car has driven a full lap length (the game stops sending data as the race
+// finishes), timed with CurrentLap plus the track's finish offset.
+// Forza doesn't send whether it invalidated a lap, so a lap is only marked valid
+// if it started at the start line, crossed every sector and was the length of the track.
+func calcLaps(session *Session, track trackDefinition) []lapRecord {
+	check(session.Require("CurrentLap", "DistanceTraveled", "LapNumber", "Speed"))
+	t := session.Float("CurrentLap")
+	d := session.Float("DistanceTraveled")
+	l := session.Float("LapNumber")
+	speeds := session.Float("Speed")
+	lastLap := session.Float("LastLap")
+	fuel := session.Float("Fuel")
+	if len(track.Gates) > 0 {
+		check(session.Require("PositionX", "PositionZ"))
+	}
+	x := session.Float("PositionX")
+	z := session.Float("PositionZ")
+
+	var laps []lapRecord
+	addLap := func(start int, end int, lapTime float64, sectors []float64, sectorEnd float64) { // end included
+		lap := lapRecord{Number: int(l[start]) + 1, Time: lapTime, Sectors: sectors, MinSpeed: math.Inf(1)}
+		lap.Sectors[len(lap.Sectors)-1] = lapTime - sectorEnd
+		total := 0.0
+		for i := start; i <= end; i++ {
+			mph := speeds[i] * speedUnits["mph"]
+			lap.TopSpeed = math.Max(lap.TopSpeed, mph)
+			lap.MinSpeed = math.Min(lap.MinSpeed, mph)
+			total += mph
+		}
+		lap.AvgSpeed = total / float64(end-start+1)
+		if fuel != nil {
+			lap.FuelUsed = (fuel[start] - fuel[end]) * 100
+		}
+
+		distance := d[end] - d[start]
+		switch {
+		case t[start] > lapStartTolerance:
+			lap.Reason = "started before the log did"
+		case !lap.hasEverySector():
+			lap.Reason = "missed a sector"
+		case math.Abs(distance-track.Length) > lapDistanceTolerance*track.Length:
+			lap.Reason = fmt.Sprintf("%.0f m long, the track is %.0f m", distance, track.Length)
+		default:
+			lap.Valid = true
+		}
+		laps = append(laps, lap)
+	}
+
+	start := -1 // first data point of the current lap
+	var sectors []float64
+	sectorEnd := 0.0 // lap time at the end of the last sector
+	sector := 0      // sector the car is in
+	for i, val := range d {
+		if val < 0 {
+			continue
+		}
+		if start < 0 || l[i] != l[start] {
+			if start >= 0 {
+				lapTime := t[i-1] + track.FinishOffset
+				if lastLap != nil && lastLap[i] > 0 {
+					lapTime = lastLap[i]
+				}
+				addLap(start, i-1, lapTime, sectors, sectorEnd)
+			}
+			start, sectorEnd, sector = i, 0, 0
+			sectors = make([]float64, track.SectorCount())
+		}
+		if len(track.Gates) > 0 {
+			// The move into a lap's first data point is timed with the last lap's CurrentLap
+			if sector < len(track.Gates) && i > start {
+				if s, ok := track.Gates[sector].crossing(x[i-1], z[i-1], x[i], z[i]); ok {
+					at := t[i-1] + s*(t[i]-t[i-1])
+					sectors[sector] = at - sectorEnd
+					sectorEnd = at
+					sector++
+				}
+			}
+		} else if sector < len(track.Sectors) && val-d[start] >= track.Sectors[sector] {
+			sectors[sector] = t[i] - sectorEnd
+			sectorEnd = t[i]
+			sector++
+		}
+	}
+	// The game stops sending data as the race finishes, before LapNumber changes
+	if end := len(d) - 1; start >= 0 && d[end]-d[start] >= track.Length {
+		addLap(start, end, t[end]+track.FinishOffset, sectors, sectorEnd)
+	}
+	return laps
+}
+
+// Returns a lap time in 00:00.000 format
+func formatLapTime(seconds float64) string {
+	min := strconv.FormatFloat(math.Floor(seconds/60), 'f', 0, 32)
+	if len(min) == 1 {
+		min = "0" + min
+	}
+	sec := strconv.FormatFloat(math.Mod(seconds, 60), 'f', 3, 32)
+	secNum, _ := strconv.ParseFloat(sec, 8)
+	if secNum < 10 {
+		sec = "0" + sec
+	}
+	return min + ":" + sec
+}
+
+// Returns a sector time in hh:mm:ss.000 duration format
+func formatSectorTime(seconds float64) string {
+	minutes := strconv.FormatFloat(math.Floor(seconds/60), 'f', 0, 32)
+	secs := strconv.FormatFloat(math.Mod(seconds, 60), 'f', 3, 32)
+	if math.Mod(seconds, 60) < 1 {
+		secs = "0" + secs
+	}
+	return "00:" + minutes + ":" + secs
+}
+
+// Returns the lap table as rows of text, starting with the column names, with
+// times in the same formats as the Stat Builder sheet
+func lapTable(laps []lapRecord, track trackDefinition) [][]string {
+	header := []string{"Lap", "Lap Time"}
+	for k := 1; k <= track.SectorCount(); k++ {
+		header = append(header, fmt.Sprintf("Sector %d", k))
+	}
+	header = append(header, "Top Speed (mph)", "Min Speed (mph)", "Avg Speed (mph)", "Fuel Used (%)", "Valid")
+	rows := [][]string{header}
+	for _, lap := range laps {
+		row := []string{strconv.Itoa(lap.Number), formatLapTime(lap.Time)}
+		for _, v := range lap.Sectors {
+			row = append(row, formatSectorTime(v))
+		}
+		valid := "Yes"
+		if !lap.Valid {
+			valid = "No (" + lap.Reason + ")"
+		}
+		row = append(row,
+			strconv.FormatFloat(lap.TopSpeed, 'f', 2, 32),
+			strconv.FormatFloat(lap.MinSpeed, 'f', 2, 32),
+			strconv.FormatFloat(lap.AvgSpeed, 'f', 2, 32),
+			strconv.FormatFloat(lap.FuelUsed, 'f', 2, 32),
+			valid)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// Prints the lap table and writes it to a .csv or .json file
+func saveLaps(name string, laps []lapRecord, track trackDefinition) {
+	printLaps(laps, track)
+	check(writeLaps(name, laps, track))
+	fmt.Printf("Wrote lap table to %s\n", name)
+}
+
+// Writes the lap table to a .csv or .json file, depending on the file's extension.
+// JSON keeps times in seconds, CSV uses the lap table's formats.
+func writeLaps(name string, laps []lapRecord, track trackDefinition) error {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		b, err := json.MarshalIndent(struct {
+			Track string      `json:"track"`
+			Laps  []lapRecord `json:"laps"`
+		}{track.Name, laps}, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(name, b, 0644); err != nil {
+			return fmt.Errorf("Cannot write '%s': %s", name, err.Error())
+		}
+		return nil
+	case ".csv":
+		f, err := os.Create(name)
+		if err != nil {
+			return fmt.Errorf("Cannot create '%s': %s", name, err.Error())
+		}
+		defer f.Close()
+		writer := csv.NewWriter(f)
+		writer.WriteAll(lapTable(laps, track))
+		return writer.Error()
+	}
+	return fmt.Errorf("Lap table file '%s' must end in .csv or .json", name)
+}
+
+// Prints the lap table
+func printLaps(laps []lapRecord, track trackDefinition) {
+	if len(laps) == 0 {
+		fmt.Println("No finished laps found in log.")
+		return
+	}
+	for _, row := range lapTable(laps, track) {
+		fmt.Println(strings.Join(row, "  "))
+	}
+}
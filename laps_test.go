@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// Radius of testRace's track, a 1000 m circle driven anticlockwise (PositionX to the right, PositionZ up)
+const raceRadius = 1000 / (2 * math.Pi)
+
+// A race at a steady 50 m/s on a 1000 m track, 10 data points a second: two laps
+// and the first data point of the third
+var testRace = buildSession([]string{"CurrentLap", "DistanceTraveled", "LapNumber", "Speed", "LastLap", "PositionX", "PositionZ"}, 401, func(i int) []float64 {
+	lastLap := 0.0
+	if i >= 200 {
+		lastLap = 20
+	}
+	angle := 2 * math.Pi * float64(i*5) / 1000
+	return []float64{float64(i%200) / 10, float64(i * 5), float64(i / 200), 50, lastLap, raceRadius * math.Cos(angle), raceRadius * math.Sin(angle)}
+})
+
+func TestCalcLaps(t *testing.T) {
+	track := trackDefinition{Name: "Test", Length: 1000, Sectors: []float64{400, 700}}
+	tests := []struct {
+		name  string
+		first int
+		last  int
+		want  []lapRecord
+	}{
+		{
+			name: "from the start line", first: 0, last: 400,
+			want: []lapRecord{
+				{Number: 1, Time: 20, Sectors: []float64{8, 6, 6}, Valid: true},
+				{Number: 2, Time: 20, Sectors: []float64{8, 6, 6}, Valid: true},
+			},
+		},
+		{
+			name: "started mid lap", first: 100, last: 400,
+			want: []lapRecord{
+				{Number: 1, Time: 20, Sectors: []float64{18, 0, 2}, Reason: "started before the log did"},
+				{Number: 2, Time: 20, Sectors: []float64{8, 6, 6}, Valid: true},
+			},
+		},
+		{
+			name: "last lap unfinished", first: 0, last: 399,
+			want: []lapRecord{
+				{Number: 1, Time: 20, Sectors: []float64{8, 6, 6}, Valid: true},
+			},
+		},
+	}
+	for _, test := range tests {
+		laps := calcLaps(testRace.Slice(test.first, test.last+1), track)
+		if len(laps) != len(test.want) {
+			t.Errorf("%s: %d laps, want %d", test.name, len(laps), len(test.want))
+			continue
+		}
+		for k, lap := range laps {
+			want := test.want[k]
+			if lap.Number != want.Number || math.Abs(lap.Time-want.Time) > 1e-9 || lap.Valid != want.Valid || lap.Reason != want.Reason {
+				t.Errorf("%s: lap %d = %+v, want %+v", test.name, k, lap, want)
+			}
+			for j := range want.Sectors {
+				want.Sectors[j] = math.Round(want.Sectors[j]*1000) / 1000
+				lap.Sectors[j] = math.Round(lap.Sectors[j]*1000) / 1000
+			}
+			if !reflect.DeepEqual(lap.Sectors, want.Sectors) {
+				t.Errorf("%s: lap %d sectors = %v, want %v", test.name, k, lap.Sectors, want.Sectors)
+			}
+			if mph := 50 * speedUnits["mph"]; math.Abs(lap.TopSpeed-mph) > 1e-3 || math.Abs(lap.AvgSpeed-mph) > 1e-3 {
+				t.Errorf("%s: lap %d speeds %.2f/%.2f, want %.2f", test.name, k, lap.TopSpeed, lap.AvgSpeed, mph)
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// Name of the extra CSV column that tags every row with the packet format (and
+// so the game) it was decoded from
+const formatColumn = "PacketFormat"
+
+// Decides which packets belong in a session's log. Locks on to the packet format
+// of the first packet that can be identified (unless a format was given), and
+// rejects packets from any other game or of unknown layout. A given format is
+// trusted for every packet of its size, and packets of any other size rejected.
+type sessionFilter struct {
+	Format   *PacketFormat  // nil until the format has been detected
+	Forced   bool           // Format was given, not detected
+	EVMode   bool           // keep packets sent in menus (IsRaceOn = 0)
+	Rejected map[string]int // number of rejected packets for each reason
+}
+
+func newSessionFilter(format *PacketFormat, evMode bool) *sessionFilter {
+	return &sessionFilter{Format: format, Forced: format != nil, EVMode: evMode, Rejected: make(map[string]int)}
+}
+
+// Decodes a datagram and returns the packet, and true if it should be logged
+func (sf *sessionFilter) accept(b []byte) (Packet, bool) {
+	if sf.Forced {
+		if len(b) != sf.Format.Size {
+			sf.reject(fmt.Sprintf("%d byte packet, but %s format packets are %d bytes (check -format)", len(b), sf.Format.Name, sf.Format.Size))
+			return Packet{}, false
+		}
+	} else {
+		packetFormat, err := detectPacketFormat(b)
+		if sf.Format == nil && err == nil { // First identified packet starts the session
+			sf.Format = packetFormat
+			fmt.Printf("Detected %s (%s format)\n", sf.Format.Game, sf.Format.Name)
+		}
+		if err != nil {
+			sf.reject(err.Error())
+			return Packet{}, false
+		}
+		if packetFormat != sf.Format {
+			sf.reject(fmt.Sprintf("%s packet during a %s session", packetFormat.Game, sf.Format.Game))
+			return Packet{}, false
+		}
+	}
+
+	packet, err := sf.Format.Decode(b)
+	if err == nil {
+		err = checkPacket(packet)
+	}
+	if err != nil {
+		sf.reject(err.Error())
+		return Packet{}, false
+	}
+	if raceOn, _ := packet.Get("IsRaceOn"); raceOn != 1 && !sf.EVMode {
+		return Packet{}, false
+	}
+	return packet, true
+}
+
+func (sf *sessionFilter) reject(reason string) {
+	if sf.Rejected[reason] == 0 { // Only report the first of each kind
+		log.Printf("Rejected packet: %s\n", reason)
+	}
+	sf.Rejected[reason]++
+}
+
+// Prints the number of rejected packets for each reason
+func (sf *sessionFilter) printRejected() {
+	for reason, n := range sf.Rejected {
+		fmt.Printf("Rejected %d packets: %s\n", n, reason)
+	}
+}
+
+// Listens for Forza Data Out packets on the given UDP port, decodes them with the
+// given packet format and writes each one as a row to csvFile, using the packet
+// field names as column headers.
+// If format is nil, the format is detected from the first packet that can be
+// identified, and packets from any other game or of unknown layout are rejected.
+// Packets are only logged while a race is on (IsRaceOn = 1) unless evMode is set,
+// in which case menus are logged too.
+// If captureFile isn't empty, every datagram received is also saved there unchanged.
+// Runs until interrupted with Ctrl+C, or until writing the log or capture fails,
+// which is returned once everything received before it has been flushed.
+func listen(port int, format *PacketFormat, csvFile string, captureFile string, evMode bool) error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		log.Fatalf("Unable to listen on UDP port %d: %v", port, err)
+	}
+	defer conn.Close()
+
+	f, err := os.Create(csvFile)
+	if err != nil {
+		log.Fatalf("Cannot create '%s': %s\n", csvFile, err.Error())
+	}
+	defer f.Close()
+
+	var capture *captureWriter
+	if captureFile != "" {
+		capture, err = createCapture(captureFile)
+		if err != nil {
+			log.Fatalf("Cannot create '%s': %s\n", captureFile, err.Error())
+		}
+		defer capture.Close()
+	}
+
+	w := csv.NewWriter(f)
+	defer w.Flush()
+	headerWritten := false
+
+	// Close the connection on Ctrl+C so the read loop ends and the log gets flushed
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		conn.Close()
+	}()
+
+	if format == nil {
+		fmt.Printf("Listening for Forza Data Out on UDP port %d, logging to %s (Ctrl+C to stop)\n", port, csvFile)
+	} else {
+		fmt.Printf("Listening for Forza Data Out (%s format) on UDP port %d, logging to %s (Ctrl+C to stop)\n", format.Name, port, csvFile)
+	}
+
+	count := 0
+	filter := newSessionFilter(format, evMode)
+	buf := make([]byte, 1500)
+	var writeErr error // Set when a write fails, ending the loop so the deferred flushes still save what was logged
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break // Connection closed
+		}
+		if capture != nil {
+			if err = capture.Write(time.Now(), buf[:n]); err != nil {
+				writeErr = fmt.Errorf("Cannot write '%s': %v", captureFile, err)
+				break
+			}
+		}
+
+		packet, ok := filter.accept(buf[:n])
+		if !ok {
+			continue
+		}
+		if !headerWritten {
+			err = w.Write(append(filter.Format.Headers(), formatColumn))
+			headerWritten = true
+		}
+		if err == nil {
+			err = w.Write(append(packet.Row(), filter.Format.Name))
+		}
+		if err == nil && (count+1)%60 == 0 { // Flush about once a second at 60 packets per second
+			w.Flush()
+			err = w.Error()
+		}
+		if err != nil {
+			writeErr = fmt.Errorf("Cannot write '%s': %v", csvFile, err)
+			break
+		}
+		count++
+	}
+
+	fmt.Printf("\nLogged %d data points to %s\n", count, csvFile)
+	filter.printRejected()
+	return writeErr
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSessionFilter(t *testing.T) {
+	menu := testPacket(packetFormats["fh"])
+	menu.Values[packetFormats["fh"].index["IsRaceOn"]] = 0
+	tests := []struct {
+		name    string
+		forced  string // -format, "" to detect it
+		evMode  bool
+		packets [][]byte
+		want    []bool // Whether each packet is logged
+		format  string // Format of the session afterwards
+		reason  string // Part of a rejection reason, "" for none
+	}{
+		{
+			name:    "detected",
+			packets: [][]byte{make([]byte, 100), testPacket(packetFormats["fh"]).Encode(), testPacket(packetFormats["fm8"]).Encode()},
+			want:    []bool{false, true, false},
+			format:  "fh",
+			reason:  "Forza Motorsport packet during a Forza Horizon 4/5 session",
+		},
+		{
+			name:    "forced",
+			forced:  "fm7sled",
+			packets: [][]byte{testPacket(packetFormats["fm7sled"]).Encode(), testPacket(packetFormats["fm7dash"]).Encode()},
+			want:    []bool{true, false},
+			format:  "fm7sled",
+			reason:  "311 byte packet, but fm7sled format packets are 232 bytes",
+		},
+		{
+			name:    "menus",
+			packets: [][]byte{menu.Encode(), testPacket(packetFormats["fh"]).Encode()},
+			want:    []bool{false, true},
+			format:  "fh",
+		},
+		{
+			name:    "menus in EV mode",
+			evMode:  true,
+			packets: [][]byte{menu.Encode()},
+			want:    []bool{true},
+			format:  "fh",
+		},
+	}
+	for _, test := range tests {
+		var format *PacketFormat
+		if test.forced != "" {
+			format = packetFormats[test.forced]
+		}
+		filter := newSessionFilter(format, test.evMode)
+		for k, b := range test.packets {
+			if _, ok := filter.accept(b); ok != test.want[k] {
+				t.Errorf("%s: packet %d logged %v, want %v", test.name, k, ok, test.want[k])
+			}
+		}
+		if filter.Format == nil || filter.Format.Name != test.format {
+			t.Errorf("%s: session format %v, want %s", test.name, filter.Format, test.format)
+		}
+		if test.reason == "" {
+			continue
+		}
+		found := false
+		for reason := range filter.Rejected {
+			found = found || strings.Contains(reason, test.reason)
+		}
+		if !found {
+			t.Errorf("%s: rejected %v, want a reason containing %q", test.name, filter.Rejected, test.reason)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Limits used to pick the data points the mass is estimated from
+const (
+	fullThrottle      = 250  // Accel is 0-255, anything above this is flat out
+	massMinSpeed      = 10.0 // m/s, below this the car is still launching (wheelspin, clutch)
+	massMinGear       = 2    // 1st gear is usually traction limited
+	minMassDataPoints = 120  // About 2 seconds of full throttle
+	kgToLb            = 2.20462
+)
+
+// The car's effective mass worked out from its power and acceleration
+type massEstimate struct {
+	Mass       float64 // kg
+	Band       float64 // kg either side of Mass, 95% confidence
+	DataPoints int
+	Found      bool
+}
+
+// Estimates the car's mass from full throttle pulls in 2nd gear or higher.
+// The engine's power goes into accelerating the car and overcoming rolling
+// resistance and drag: Power = m·a·v + Cr·v + Cd·v³. Fitting that to every data
+// point by least squares gives the mass (m) along with the two loss terms, and
+// the spread of the data around the fit gives the confidence band. This is the
+// effective mass, so it also includes the spinning parts of the drivetrain and
+// any power lost in it.
+func estimateMass(session *Session) massEstimate {
+	var estimate massEstimate
+	if session.Require("Power", "Speed", "AccelerationZ", "Accel", "Gear") != nil {
+		return estimate // e.g. the FM7 "sled" format has no power data
+	}
+	power := session.Float("Power")
+	speeds := session.Float("Speed")
+	accel := session.Float("AccelerationZ")
+	throttle := session.Float("Accel")
+	gear := session.Float("Gear")
+	clutch := session.Float("Clutch")
+	brake := session.Float("Brake")
+
+	// Least squares sums for Power = m·(a·v) + Cr·v + Cd·v³
+	var xtx [3][3]float64
+	var xty [3]float64
+	var rows [][4]float64
+	for i := range power {
+		if throttle[i] < fullThrottle || gear[i] < massMinGear || speeds[i] < massMinSpeed || power[i] <= 0 ||
+			(clutch != nil && clutch[i] > 0) || (brake != nil && brake[i] > 0) {
+			continue
+		}
+		v := speeds[i]
+		x := [3]float64{accel[i] * v, v, v * v * v}
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				xtx[j][k] += x[j] * x[k]
+			}
+			xty[j] += x[j] * power[i]
+		}
+		rows = append(rows, [4]float64{x[0], x[1], x[2], power[i]})
+	}
+	if len(rows) < minMassDataPoints {
+		return estimate
+	}
+	inverse, ok := invert3(xtx)
+	if !ok {
+		return estimate // All the data points were at the same speed and acceleration
+	}
+	var coefficients [3]float64
+	for j := 0; j < 3; j++ {
+		for k := 0; k < 3; k++ {
+			coefficients[j] += inverse[j][k] * xty[k]
+		}
+	}
+
+	// Standard error of the mass from the residuals of the fit
+	residuals := 0.0
+	for _, row := range rows {
+		predicted := coefficients[0]*row[0] + coefficients[1]*row[1] + coefficients[2]*row[2]
+		residuals += (row[3] - predicted) * (row[3] - predicted)
+	}
+	variance := residuals / float64(len(rows)-3)
+	standardError := math.Sqrt(variance * inverse[0][0])
+
+	if coefficients[0] <= 0 {
+		return estimate // Power and acceleration don't line up, e.g. pulls up and down hills
+	}
+	estimate.Mass = coefficients[0]
+	estimate.Band = 1.96 * standardError
+	estimate.DataPoints = len(rows)
+	estimate.Found = true
+	return estimate
+}
+
+// Returns the inverse of a 3x3 matrix, or false if it can't be inverted
+func invert3(m [3][3]float64) ([3][3]float64, bool) {
+	var inverse [3][3]float64
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if det == 0 || math.IsNaN(det) {
+		return inverse, false
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			// Cofactor of m[j][i], using the rows and columns that aren't j and i
+			r0, r1 := (j+1)%3, (j+2)%3
+			c0, c1 := (i+1)%3, (i+2)%3
+			inverse[i][j] = (m[r0][c0]*m[r1][c1] - m[r0][c1]*m[r1][c0]) / det
+		}
+	}
+	return inverse, true
+}
+
+// Parses a weight in pounds from the Ordinal Data sheet (e.g. "3,042" or "3042 lb").
+// Returns false if the cell is empty or isn't a weight.
+func parseWeight(s string) (float64, bool) {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(strings.ReplaceAll(s, ",", "")), "lb"))
+	lb, err := strconv.ParseFloat(s, 64)
+	if err != nil || lb <= 0 {
+		return 0, false
+	}
+	return lb, true
+}
+
+// Returns power to weight in hp per metric tonne
+func powerToWeight(hp float64, kg float64) float64 {
+	return hp / (kg / 1000)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEstimateMass(t *testing.T) {
+	// Full throttle pulls in 3rd gear of a car with the given mass (kg) and losses
+	tests := []struct {
+		name    string
+		mass    float64
+		rolling float64
+		drag    float64
+		seconds float64
+		want    float64
+		found   bool
+	}{
+		{"light car", 1200, 150, 0.35, 10, 1200, true},
+		{"heavy car", 2400, 300, 0.5, 10, 2400, true},
+		{"too short", 1200, 150, 0.35, 1, 0, false},
+	}
+	for _, test := range tests {
+		// Engine power rises and falls with speed so the fit has different speeds and accelerations to work with
+		speed := 12.0
+		session := buildSession([]string{"TimestampMS", "Speed", "Power", "AccelerationZ", "Accel", "Gear"}, int(test.seconds*60), func(i int) []float64 {
+			power := 150000 + 50000*math.Sin(float64(i)/40)
+			accel := (power - test.rolling*speed - test.drag*speed*speed*speed) / (test.mass * speed)
+			row := []float64{float64(i) * 1000 / 60, speed, power, accel, 255, 3}
+			speed += accel / 60
+			return row
+		})
+		estimate := estimateMass(session)
+		if estimate.Found != test.found || math.Abs(estimate.Mass-test.want) > 0.01 {
+			t.Errorf("%s: estimateMass = %.2f ± %.2f kg (found %v), want %.2f kg (found %v)",
+				test.name, estimate.Mass, estimate.Band, estimate.Found, test.want, test.found)
+		}
+	}
+
+	// The FM7 "sled" format has no power data
+	sled := buildSession([]string{"TimestampMS", "Speed"}, 600, func(i int) []float64 { return []float64{float64(i), 20} })
+	if estimate := estimateMass(sled); estimate.Found {
+		t.Errorf("estimateMass without power = %.2f kg, want not found", estimate.Mass)
+	}
+}
+
+func TestInvert3(t *testing.T) {
+	m := [3][3]float64{{2, 0, 1}, {1, 3, 2}, {1, 1, 2}}
+	inverse, ok := invert3(m)
+	if !ok {
+		t.Fatalf("invert3 couldn't invert %v", m)
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			product := 0.0
+			for k := 0; k < 3; k++ {
+				product += m[i][k] * inverse[k][j]
+			}
+			if want := map[bool]float64{true: 1, false: 0}[i == j]; math.Abs(product-want) > 1e-12 {
+				t.Errorf("m × invert3(m) [%d][%d] = %g, want %g", i, j, product, want)
+			}
+		}
+	}
+	if _, ok := invert3([3][3]float64{{1, 2, 3}, {2, 4, 6}, {1, 1, 1}}); ok {
+		t.Errorf("invert3 inverted a singular matrix")
+	}
+}
+
+func TestParseWeight(t *testing.T) {
+	tests := []struct {
+		cell  string
+		want  float64
+		valid bool
+	}{
+		{"3042", 3042, true},
+		{"3,042", 3042, true},
+		{" 3042 lb ", 3042, true},
+		{"", 0, false},
+		{"N/A", 0, false},
+		{"0", 0, false},
+	}
+	for _, test := range tests {
+		got, valid := parseWeight(test.cell)
+		if got != test.want || valid != test.valid {
+			t.Errorf("parseWeight(%q) = %g, %v, want %g, %v", test.cell, got, valid, test.want, test.valid)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A lap made up of sectors from different laps
+type optimalLap struct {
+	Time    float64   // Seconds
+	Sectors []float64 // Seconds
+	Laps    []int     // The lap each sector came from
+	First   int       // Index of the sector it starts with (0 for the start line)
+	Found   bool      // False if there were no valid laps to build it from
+}
+
+// Returns the theoretical best lap: the best time of each sector over every valid
+// lap, added up. Invalid laps are left out so a cut corner or a lap that started
+// before the log can't give a sector time that was never really driven.
+func theoreticalBestLap(laps []lapRecord, sectorCount int) optimalLap {
+	best := optimalLap{Sectors: make([]float64, sectorCount), Laps: make([]int, sectorCount)}
+	for _, lap := range laps {
+		if !lap.Valid {
+			continue
+		}
+		for k, v := range lap.Sectors {
+			if !best.Found || v < best.Sectors[k] {
+				best.Sectors[k], best.Laps[k] = v, lap.Number
+			}
+		}
+		best.Found = true
+	}
+	for _, v := range best.Sectors {
+		best.Time += v
+	}
+	return best
+}
+
+// Returns the rolling optimal lap: the quickest run of one lap's worth of
+// consecutive sectors, starting at any sector, e.g. sectors 3 and 4 of lap 2 then
+// sectors 1 and 2 of lap 3. It's a lap that was really driven in one go, just not
+// from the start line. Only runs of back to back valid laps are used.
+func rollingOptimalLap(laps []lapRecord, sectorCount int) optimalLap {
+	var best optimalLap
+	var sectors []float64 // sectors of the current run of back to back valid laps
+	var lapNumbers []int
+	for i, lap := range laps {
+		if !lap.Valid {
+			sectors, lapNumbers = nil, nil
+			continue
+		}
+		if i > 0 && laps[i-1].Number != lap.Number-1 {
+			sectors, lapNumbers = nil, nil
+		}
+		for _, v := range lap.Sectors {
+			sectors = append(sectors, v)
+			lapNumbers = append(lapNumbers, lap.Number)
+		}
+		// Every window of sectorCount sectors ending in this lap
+		for end := len(sectors) - len(lap.Sectors) + 1; end <= len(sectors); end++ {
+			start := end - sectorCount
+			if start < 0 {
+				continue
+			}
+			time := 0.0
+			for _, v := range sectors[start:end] {
+				time += v
+			}
+			if !best.Found || time < best.Time {
+				best = optimalLap{
+					Time:    time,
+					Sectors: append([]float64{}, sectors[start:end]...),
+					Laps:    append([]int{}, lapNumbers[start:end]...),
+					First:   start % sectorCount,
+					Found:   true,
+				}
+			}
+		}
+	}
+	return best
+}
+
+// Returns the quickest valid lap, or a lap with Valid false if there are none
+func bestValidLap(laps []lapRecord) lapRecord {
+	var best lapRecord
+	for _, lap := range laps {
+		if lap.Valid && (!best.Valid || lap.Time < best.Time) {
+			best = lap
+		}
+	}
+	return best
+}
+
+// Returns the gap from a lap time to the optimal lap in seconds, in +0.000 format
+func (optimal optimalLap) gap(lapTime float64) string {
+	if !optimal.Found {
+		return "N/A"
+	}
+	gap := strconv.FormatFloat(lapTime-optimal.Time, 'f', 3, 64)
+	if !strings.HasPrefix(gap, "-") {
+		gap = "+" + gap
+	}
+	return gap
+}
+
+// Prints an optimal lap, the best valid lap's gap to it and which laps its sectors came from
+func (optimal optimalLap) print(name string, bestLap lapRecord) {
+	if !optimal.Found {
+		fmt.Printf("%s: N/A (no valid laps)\n", name)
+		return
+	}
+	var from []string
+	for k, lap := range optimal.Laps {
+		from = append(from, fmt.Sprintf("S%d lap %d", (optimal.First+k)%len(optimal.Laps)+1, lap))
+	}
+	fmt.Printf("%s: %s (best valid lap, lap %d, is %s s off it)  %s\n", name, formatLapTime(optimal.Time), bestLap.Number, optimal.gap(bestLap.Time), strings.Join(from, ", "))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOptimalLaps(t *testing.T) {
+	lap := func(number int, valid bool, sectors ...float64) lapRecord {
+		return lapRecord{Number: number, Time: total(sectors), Sectors: sectors, Valid: valid}
+	}
+	tests := []struct {
+		name        string
+		laps        []lapRecord
+		theoretical optimalLap
+		rolling     optimalLap
+		best        int // Number of the best valid lap, 0 for none
+	}{
+		{
+			name:        "no valid laps",
+			laps:        []lapRecord{lap(1, false, 10, 10, 10)},
+			theoretical: optimalLap{Sectors: []float64{0, 0, 0}, Laps: []int{0, 0, 0}},
+		},
+		{
+			name:        "one lap",
+			laps:        []lapRecord{lap(1, true, 10, 11, 12)},
+			theoretical: optimalLap{Time: 33, Sectors: []float64{10, 11, 12}, Laps: []int{1, 1, 1}, Found: true},
+			rolling:     optimalLap{Time: 33, Sectors: []float64{10, 11, 12}, Laps: []int{1, 1, 1}, Found: true},
+			best:        1,
+		},
+		{
+			// Lap 2's last sector and lap 3's first two make the quickest run
+			name:        "across laps",
+			laps:        []lapRecord{lap(1, true, 10, 11, 13), lap(2, true, 11, 12, 10), lap(3, true, 9, 10, 13)},
+			theoretical: optimalLap{Time: 29, Sectors: []float64{9, 10, 10}, Laps: []int{3, 3, 2}, Found: true},
+			rolling:     optimalLap{Time: 29, Sectors: []float64{10, 9, 10}, Laps: []int{2, 3, 3}, First: 2, Found: true},
+			best:        3,
+		},
+		{
+			// Lap 2 is invalid, so lap 1's end and lap 3's start aren't back to back
+			name:        "invalid lap breaks the run",
+			laps:        []lapRecord{lap(1, true, 10, 11, 8), lap(2, false, 5, 5, 5), lap(3, true, 8, 12, 13)},
+			theoretical: optimalLap{Time: 27, Sectors: []float64{8, 11, 8}, Laps: []int{3, 1, 1}, Found: true},
+			rolling:     optimalLap{Time: 29, Sectors: []float64{10, 11, 8}, Laps: []int{1, 1, 1}, Found: true},
+			best:        1,
+		},
+		{
+			// Lap 3 is missing from the log, so laps 2 and 4 aren't back to back
+			name:        "missing lap breaks the run",
+			laps:        []lapRecord{lap(2, true, 10, 11, 8), lap(4, true, 8, 12, 13)},
+			theoretical: optimalLap{Time: 27, Sectors: []float64{8, 11, 8}, Laps: []int{4, 2, 2}, Found: true},
+			rolling:     optimalLap{Time: 29, Sectors: []float64{10, 11, 8}, Laps: []int{2, 2, 2}, Found: true},
+			best:        2,
+		},
+	}
+	for _, test := range tests {
+		if got := theoreticalBestLap(test.laps, 3); !reflect.DeepEqual(got, test.theoretical) {
+			t.Errorf("%s: theoreticalBestLap = %+v, want %+v", test.name, got, test.theoretical)
+		}
+		if got := rollingOptimalLap(test.laps, 3); !reflect.DeepEqual(got, test.rolling) {
+			t.Errorf("%s: rollingOptimalLap = %+v, want %+v", test.name, got, test.rolling)
+		}
+		if got := bestValidLap(test.laps); got.Number != test.best || got.Valid != (test.best > 0) {
+			t.Errorf("%s: bestValidLap = lap %d, want lap %d", test.name, got.Number, test.best)
+		}
+	}
+}
+
+func TestOptimalGap(t *testing.T) {
+	optimal := optimalLap{Time: 90.5, Found: true}
+	tests := []struct {
+		optimal optimalLap
+		lapTime float64
+		want    string
+	}{
+		{optimal, 90.912, "+0.412"},
+		{optimal, 90.5, "+0.000"},
+		{optimal, 90.25, "-0.250"},
+		{optimalLap{}, 90, "N/A"},
+	}
+	for _, test := range tests {
+		if got := test.optimal.gap(test.lapTime); got != test.want {
+			t.Errorf("gap(%g) from %g = %s, want %s", test.lapTime, test.optimal.Time, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"embed"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Packet layouts, declared the same way as FM8_packetformat.dat
+//
+//go:embed *_packetformat.dat
+var packetFormatFiles embed.FS
+
+// Packet format names (used by the -format flag), the game(s) that send them,
+// and the file declaring each layout
+var packetFormatSources = []struct {
+	Name    string
+	Game    string
+	File    string
+	Classes []string // Car class letters, indexed by CarClass
+}{
+	{"fm7sled", "Forza Motorsport 7 (sled)", "FM7_sled_packetformat.dat", fm7Classes},
+	{"fm7dash", "Forza Motorsport 7 (car dash)", "FM7_dash_packetformat.dat", fm7Classes},
+	{"fh", "Forza Horizon 4/5", "FH_packetformat.dat", fhClasses},   // Dash format with unknown padding bytes
+	{"fm8", "Forza Motorsport", "FM8_packetformat.dat", fm8Classes}, // Adds tire wear and TrackOrdinal
+}
+
+// Car class letters of each game, from CarClass 0 (slowest cars) up
+var (
+	fm7Classes = []string{"D", "C", "B", "A", "S", "R", "P", "X"}
+	fhClasses  = []string{"D", "C", "B", "A", "S1", "S2", "X"}
+	fm8Classes = []string{"E", "D", "C", "B", "A", "S", "R", "P", "X"}
+)
+
+// A single field of a Forza "Data Out" packet
+type packetField struct {
+	Name    string
+	Type    string // s32, u32, f32, u16, u8 or s8
+	Count   int    // Number of values, > 1 for array fields
+	Offset  int    // byte offset into the packet
+	Padding bool   // Declared with brackets (e.g. "u8 Placeholder[1]"), unknown bytes without a column
+}
+
+// Field layout of one packet format, read from a .dat file
+type PacketFormat struct {
+	Name    string
+	Game    string
+	Fields  []packetField
+	Size    int            // total packet size in bytes
+	Classes []string       // car class letters, indexed by CarClass
+	index   map[string]int // field name -> position in Packet.Values
+	named   []packetField  // fields with values (padding removed), in packet order
+}
+
+// A decoded packet. Values are addressable by the same field names used as CSV headers.
+type Packet struct {
+	Format *PacketFormat
+	Values []float64
+}
+
+var packetFormats = loadPacketFormats()
+
+// Parses every embedded packet format file, exiting if any of them is invalid
+func loadPacketFormats() map[string]*PacketFormat {
+	formats := make(map[string]*PacketFormat)
+	for _, source := range packetFormatSources {
+		src, err := packetFormatFiles.ReadFile(source.File)
+		check(err)
+		format, err := parsePacketFormat(source.Name, string(src))
+		if err != nil {
+			log.Fatalf("Invalid packet format file %s: %v", source.File, err)
+		}
+		format.Game = source.Game
+		format.Classes = source.Classes
+		formats[source.Name] = format
+	}
+	return formats
+}
+
+// Returns the packet format with the given name, or an error listing the valid names
+func getPacketFormat(name string) (*PacketFormat, error) {
+	if format, isPresent := packetFormats[name]; isPresent {
+		return format, nil
+	}
+	var names []string
+	for _, source := range packetFormatSources {
+		names = append(names, source.Name)
+	}
+	return nil, fmt.Errorf("Unknown packet format '%s' (expected one of %s)", name, strings.Join(names, ", "))
+}
+
+// Works out the packet format of a datagram from its size, then checks that the
+// decoded values make sense for that layout so that other UDP traffic of the same
+// size isn't logged as telemetry.
+// Returns an error if the packet can't be identified.
+func detectPacketFormat(b []byte) (*PacketFormat, error) {
+	for _, source := range packetFormatSources {
+		format := packetFormats[source.Name]
+		if format.Size != len(b) {
+			continue
+		}
+		packet, err := format.Decode(b)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkPacket(packet); err != nil {
+			return nil, fmt.Errorf("%d byte packet looks like %s format but %v", len(b), format.Name, err)
+		}
+		return format, nil
+	}
+	return nil, fmt.Errorf("Unrecognized %d byte packet", len(b))
+}
+
+// Returns an error if a decoded packet has values that the game would never send
+func checkPacket(p Packet) error {
+	for i, v := range p.Values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("%s is not a number", p.Format.named[i].Name)
+		}
+	}
+	raceOn, _ := p.Get("IsRaceOn")
+	if raceOn != 0 && raceOn != 1 {
+		return fmt.Errorf("IsRaceOn is %v", raceOn)
+	}
+	if raceOn == 0 { // Most values are zero in menus, so there's nothing else to check
+		return nil
+	}
+
+	// Range checks for values that are always set while driving
+	limits := []struct {
+		Name     string
+		Min, Max float64
+	}{
+		{"EngineMaxRpm", 1, 50000},
+		{"CarOrdinal", 1, math.MaxInt32},
+		{"CarClass", 0, 10},
+		{"CarPerformanceIndex", 100, 999},
+		{"DrivetrainType", 0, 2},
+		{"NumCylinders", 0, 16},
+	}
+	for _, limit := range limits {
+		v, _ := p.Get(limit.Name)
+		if v < limit.Min || v > limit.Max {
+			return fmt.Errorf("%s is %v", limit.Name, v)
+		}
+	}
+	return nil
+}
+
+// Returns the size in bytes of a field type
+func fieldSize(fieldType string) int {
+	switch fieldType {
+	case "s32", "u32", "f32":
+		return 4
+	case "u16":
+		return 2
+	case "u8", "s8":
+		return 1
+	}
+	return 0
+}
+
+// Parses a packet format declaration such as FM8_packetformat.dat.
+// Each line declares one field as "<type> <Name>;" with an optional // comment.
+// Fields declared with brackets ("u8 Unknown[12];", even "[1]") are padding, skipped over when decoding.
+func parsePacketFormat(name string, src string) (*PacketFormat, error) {
+	format := &PacketFormat{Name: name, index: make(map[string]int)}
+	for lineNum, line := range strings.Split(src, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line), ";"))
+		if line == "" {
+			continue
+		}
+
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"<type> <name>;\", got %q", lineNum+1, line)
+		}
+		field := packetField{Name: parts[1], Type: parts[0], Count: 1, Offset: format.Size}
+		if fieldSize(field.Type) == 0 {
+			return nil, fmt.Errorf("line %d: unknown field type %q", lineNum+1, field.Type)
+		}
+		if open := strings.Index(field.Name, "["); open >= 0 && strings.HasSuffix(field.Name, "]") {
+			count, err := strconv.Atoi(field.Name[open+1 : len(field.Name)-1])
+			if err != nil || count < 1 {
+				return nil, fmt.Errorf("line %d: invalid array length in %q", lineNum+1, field.Name)
+			}
+			field.Name = field.Name[:open]
+			field.Count = count
+			field.Padding = true
+		}
+		if _, isPresent := format.index[field.Name]; isPresent {
+			return nil, fmt.Errorf("line %d: duplicate field %q", lineNum+1, field.Name)
+		}
+
+		format.Fields = append(format.Fields, field)
+		format.Size += fieldSize(field.Type) * field.Count
+		if !field.Padding {
+			format.index[field.Name] = len(format.named)
+			format.named = append(format.named, field)
+		}
+	}
+	if len(format.named) == 0 {
+		return nil, fmt.Errorf("no fields declared")
+	}
+	return format, nil
+}
+
+// Returns the letter of a CarClass value in this format's game (e.g. 4 is "S1" in
+// Forza Horizon but "A" in Forza Motorsport), or the number if it isn't known
+func (format *PacketFormat) ClassLetter(class int) string {
+	if class < 0 || class >= len(format.Classes) {
+		return strconv.Itoa(class)
+	}
+	return format.Classes[class]
+}
+
+// Returns the column headers for a CSV log, one per (non-padding) packet field
+func (format *PacketFormat) Headers() []string {
+	var headers []string
+	for _, f := range format.named {
+		headers = append(headers, f.Name)
+	}
+	return headers
+}
+
+// Returns true if the format has a field with the given name
+func (format *PacketFormat) Has(name string) bool {
+	_, isPresent := format.index[name]
+	return isPresent
+}
+
+// Decodes a single Data Out packet.
+// Returns an error if the packet is too short for the field layout.
+func (format *PacketFormat) Decode(b []byte) (Packet, error) {
+	if len(b) < format.Size {
+		return Packet{}, fmt.Errorf("Packet is %d bytes, expected at least %d for %s format", len(b), format.Size, format.Name)
+	}
+
+	values := make([]float64, len(format.named))
+	for i, f := range format.named {
+		switch f.Type {
+		case "s32":
+			values[i] = float64(int32(binary.LittleEndian.Uint32(b[f.Offset:])))
+		case "u32":
+			values[i] = float64(binary.LittleEndian.Uint32(b[f.Offset:]))
+		case "f32":
+			values[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[f.Offset:])))
+		case "u16":
+			values[i] = float64(binary.LittleEndian.Uint16(b[f.Offset:]))
+		case "u8":
+			values[i] = float64(b[f.Offset])
+		case "s8":
+			values[i] = float64(int8(b[f.Offset]))
+		}
+	}
+	return Packet{Format: format, Values: values}, nil
+}
+
+// Returns the value of the named field, and false if the packet format doesn't have it
+func (p Packet) Get(name string) (float64, bool) {
+	i, isPresent := p.Format.index[name]
+	if !isPresent {
+		return 0, false
+	}
+	return p.Values[i], true
+}
+
+// Returns the packet values as a CSV row, in the same order as Format.Headers()
+func (p Packet) Row() []string {
+	row := make([]string, len(p.Values))
+	for i, f := range p.Format.named {
+		if f.Type == "f32" {
+			row[i] = strconv.FormatFloat(p.Values[i], 'f', -1, 32)
+		} else {
+			row[i] = strconv.FormatInt(int64(p.Values[i]), 10)
+		}
+	}
+	return row
+}
+
+// Builds a packet from a data point of a session, matching fields to channels by name.
+// Fields that aren't in the session are left as 0.
+func (format *PacketFormat) FromFrame(f Frame) Packet {
+	values := make([]float64, len(format.named))
+	for i, field := range format.named {
+		if f.Session.Has(field.Name) {
+			values[i] = f.Float(field.Name)
+		}
+	}
+	return Packet{Format: format, Values: values}
+}
+
+// Encodes a packet into the bytes the game would send. Padding bytes are left as 0.
+func (p Packet) Encode() []byte {
+	b := make([]byte, p.Format.Size)
+	for i, f := range p.Format.named {
+		v := p.Values[i]
+		switch f.Type {
+		case "s32":
+			binary.LittleEndian.PutUint32(b[f.Offset:], uint32(int32(v)))
+		case "u32":
+			binary.LittleEndian.PutUint32(b[f.Offset:], uint32(v))
+		case "f32":
+			binary.LittleEndian.PutUint32(b[f.Offset:], math.Float32bits(float32(v)))
+		case "u16":
+			binary.LittleEndian.PutUint16(b[f.Offset:], uint16(v))
+		case "u8":
+			b[f.Offset] = uint8(v)
+		case "s8":
+			b[f.Offset] = uint8(int8(v))
+		}
+	}
+	return b
+}
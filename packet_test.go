@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePacketFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		size    int
+		headers []string
+		err     string // Part of the error, "" for none
+	}{
+		{"fields", "s32 IsRaceOn; // 1 in a race\nu32 TimestampMS;\n\nf32 Speed;", 12, []string{"IsRaceOn", "TimestampMS", "Speed"}, ""},
+		{"padding", "u8 Gear;\nu8 Placeholder[12];\ns8 Steer;", 14, []string{"Gear", "Steer"}, ""},
+		{"padding of one byte", "u8 Gear;\nu8 HorizonPlaceholder[1];", 2, []string{"Gear"}, ""},
+		{"unknown type", "f64 Speed;", 0, nil, "unknown field type"},
+		{"no name", "f32;", 0, nil, "expected"},
+		{"bad array length", "u8 Placeholder[0];", 0, nil, "invalid array length"},
+		{"duplicate", "f32 Speed;\nf32 Speed;", 0, nil, "duplicate field"},
+		{"only padding", "u8 Placeholder[4];", 0, nil, "no fields"},
+	}
+	for _, test := range tests {
+		format, err := parsePacketFormat(test.name, test.src)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, want one containing %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if format.Size != test.size || !reflect.DeepEqual(format.Headers(), test.headers) {
+			t.Errorf("%s: %d bytes %v, want %d bytes %v", test.name, format.Size, format.Headers(), test.size, test.headers)
+		}
+	}
+}
+
+// Returns a packet with a different value in every field, and values in range for
+// the fields checkPacket checks
+func testPacket(format *PacketFormat) Packet {
+	valid := map[string]float64{"IsRaceOn": 1, "EngineMaxRpm": 8000, "CarOrdinal": 2352, "CarClass": 3, "CarPerformanceIndex": 700, "DrivetrainType": 1, "NumCylinders": 8}
+	values := make([]float64, len(format.named))
+	for i, f := range format.named {
+		switch f.Type {
+		case "s32":
+			values[i] = float64(-1000 * i)
+		case "u32":
+			values[i] = float64(1000 * i)
+		case "f32":
+			values[i] = float64(i) + 0.25
+		case "u16":
+			values[i] = float64(10 * i)
+		case "u8":
+			values[i] = float64(i % 256)
+		case "s8":
+			values[i] = float64(-(i % 128))
+		}
+		if v, isPresent := valid[f.Name]; isPresent {
+			values[i] = v
+		}
+	}
+	return Packet{Format: format, Values: values}
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	sizes := map[string]int{"fm7sled": 232, "fm7dash": 311, "fh": 324, "fm8": 331}
+	for name, size := range sizes {
+		format := packetFormats[name]
+		if format.Size != size {
+			t.Errorf("%s: %d bytes, want %d", name, format.Size, size)
+			continue
+		}
+		packet := testPacket(format)
+		b := packet.Encode()
+		detected, err := detectPacketFormat(b)
+		if err != nil || detected != format {
+			t.Errorf("%s: detected as %v (%v)", name, detected, err)
+			continue
+		}
+		decoded, err := format.Decode(b)
+		if err != nil || !reflect.DeepEqual(decoded.Values, packet.Values) {
+			t.Errorf("%s: decoded %v (%v), want %v", name, decoded.Values, err, packet.Values)
+		}
+	}
+	if _, err := packetFormats["fm8"].Decode(make([]byte, 100)); err == nil {
+		t.Errorf("Decode of a short packet didn't fail")
+	}
+	if _, err := detectPacketFormat(make([]byte, 100)); err == nil {
+		t.Errorf("detectPacketFormat of an unknown size didn't fail")
+	}
+}
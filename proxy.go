@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A downstream consumer that datagrams are forwarded to
+type forwardTarget struct {
+	Address string
+	conn    net.Conn
+	queue   chan []byte // datagrams waiting to be sent
+	sent    uint64
+	dropped uint64 // datagrams lost because the queue was full or the send failed
+}
+
+// Sends queued datagrams to the target until the queue is closed
+func (target *forwardTarget) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for b := range target.queue {
+		if _, err := target.conn.Write(b); err != nil {
+			atomic.AddUint64(&target.dropped, 1) // Usually nothing listening on the target port
+			continue
+		}
+		atomic.AddUint64(&target.sent, 1)
+	}
+}
+
+// Receives Data Out packets on the given UDP port and sends each one, unchanged,
+// to every target address ("host:port"). Each target has its own send queue, so
+// one slow or unreachable consumer can't hold up the others; packets that don't
+// fit in its queue are dropped and counted.
+// Runs until interrupted with Ctrl+C.
+func forward(port int, addresses []string) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		log.Fatalf("Unable to listen on UDP port %d: %v", port, err)
+	}
+	defer conn.Close()
+
+	var targets []*forwardTarget
+	var wg sync.WaitGroup
+	for _, address := range addresses {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		targetConn, err := net.Dial("udp", address)
+		if err != nil {
+			log.Fatalf("Unable to send to %s: %v", address, err)
+		}
+		defer targetConn.Close()
+		target := &forwardTarget{Address: address, conn: targetConn, queue: make(chan []byte, 256)}
+		targets = append(targets, target)
+		wg.Add(1)
+		go target.run(&wg)
+	}
+	if len(targets) == 0 {
+		log.Fatalf("No addresses to forward to")
+	}
+
+	// Close the connection on Ctrl+C so the read loop ends
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		conn.Close()
+	}()
+
+	fmt.Printf("Forwarding Forza Data Out from UDP port %d to %s (Ctrl+C to stop)\n", port, strings.Join(addresses, ", "))
+
+	received := 0
+	lastReport := time.Now()
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break // Connection closed
+		}
+		received++
+
+		for _, target := range targets {
+			b := make([]byte, n) // Each target gets its own copy since buf is reused
+			copy(b, buf[:n])
+			select {
+			case target.queue <- b:
+			default: // Queue is full, so the target can't keep up
+				atomic.AddUint64(&target.dropped, 1)
+			}
+		}
+
+		if time.Since(lastReport) > 30*time.Second {
+			printForwardCounters(received, targets)
+			lastReport = time.Now()
+		}
+	}
+
+	for _, target := range targets {
+		close(target.queue)
+	}
+	wg.Wait()
+	fmt.Println()
+	printForwardCounters(received, targets)
+}
+
+// Prints how many packets each target has been sent and how many were dropped
+func printForwardCounters(received int, targets []*forwardTarget) {
+	fmt.Printf("Received %d packets\n", received)
+	for _, target := range targets {
+		fmt.Printf("  %s: sent %d, dropped %d\n", target.Address, atomic.LoadUint64(&target.sent), atomic.LoadUint64(&target.dropped))
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Limits used to find where a roll race starts
+const (
+	rollSteadyMS        = 1000 // The car has to cruise this long before the throttle goes down
+	rollSteadyTolerance = 2.0  // mph the speed can wander while cruising
+	rollMinSpeed        = 10.0 // mph, slower than this is a launch from a stop, not a roll
+	rollStartTolerance  = 5.0  // mph the cruising speed can be from a roll race's start speed
+)
+
+// Parses a comma separated list of roll races such as "40-140,60-130" or
+// "100-200km/h" into speed intervals (mph unless a unit is given)
+func parseRollRaces(list string) ([]speedInterval, error) {
+	var races []speedInterval
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		race := speedInterval{}
+		for unit := range speedUnits {
+			if strings.HasSuffix(s, unit) {
+				race.Unit = unit
+				s = strings.TrimSpace(strings.TrimSuffix(s, unit))
+			}
+		}
+		speeds := strings.SplitN(s, "-", 2)
+		if len(speeds) != 2 {
+			return nil, fmt.Errorf("Roll race %q should be two speeds, e.g. 40-140", s)
+		}
+		var err1, err2 error
+		race.From, err1 = strconv.ParseFloat(strings.TrimSpace(speeds[0]), 64)
+		race.To, err2 = strconv.ParseFloat(strings.TrimSpace(speeds[1]), 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("Roll race %q should be two speeds, e.g. 40-140", s)
+		}
+		if race.From >= race.To {
+			return nil, fmt.Errorf("Roll race %q has to end faster than it starts", s)
+		}
+		races = append(races, race)
+	}
+	return normalizeIntervals(races)
+}
+
+// A roll: the car cruising at a steady speed, then flooring it
+type rollRace struct {
+	Launch    int     // Data point the throttle reached wide open
+	StartTime float64 // Seconds since the start of the log
+	Speed     float64 // m/s at the launch
+	Gear      int     // Gear at the launch (0 if the log has no gear data)
+	Results   []rollResult
+}
+
+// The time of one roll race from a roll
+type rollResult struct {
+	Name        string  // The roll race's name, with the speed it was timed from if that's above its start speed
+	Time        float64 // Seconds from the start speed (or the launch, if floored above it) to the end speed
+	Uncertainty float64 // Seconds
+	Found       bool    // False if the car didn't reach the end speed before lifting
+}
+
+// Finds every time the throttle went to wide open after the car had been
+// cruising at a steady speed (within rollSteadyTolerance for rollSteadyMS,
+// without full throttle), and times each roll race whose start speed matches
+// to its end speed. A roll floored below the start speed is timed from the moment
+// it passes the start speed; one floored above it can only be timed from the
+// launch, so that speed goes in the result's name. The pull ends if the brakes go
+// on or the throttle is off for dragLiftMS, which fails the roll race.
+func calcRollRaces(session *Session, races []speedInterval) []rollRace {
+	check(session.Require("TimestampMS", "Speed", "Accel"))
+	t := session.Float("TimestampMS")
+	seconds := make([]float64, len(t))
+	for i, v := range t {
+		seconds[i] = (v - t[0]) / 1000
+	}
+	speeds := session.Float("Speed")
+	throttle := session.Float("Accel")
+	brake := session.Float("Brake")
+	gear := session.Int("Gear")
+
+	var rolls []rollRace
+	for i := 1; i < len(speeds); i++ {
+		if throttle[i] < fullThrottle || throttle[i-1] >= fullThrottle || speeds[i]*speedUnits["mph"] < rollMinSpeed {
+			continue
+		}
+		// Check the car was cruising for rollSteadyMS before the throttle went down
+		low, high := speeds[i-1], speeds[i-1]
+		steady := false
+		for k := i - 1; k >= 0 && throttle[k] < fullThrottle; k-- {
+			low, high = math.Min(low, speeds[k]), math.Max(high, speeds[k])
+			if (high-low)*speedUnits["mph"] > rollSteadyTolerance {
+				break
+			}
+			if t[i-1]-t[k] >= rollSteadyMS {
+				steady = true
+				break
+			}
+		}
+		if !steady {
+			continue
+		}
+
+		roll := rollRace{Launch: i, StartTime: seconds[i], Speed: speeds[i]}
+		if gear != nil {
+			roll.Gear = gear[i]
+		}
+		for _, race := range races {
+			unit := speedUnits[race.Unit]
+			if math.Abs(speeds[i]*unit-race.From) <= rollStartTolerance*unit/speedUnits["mph"] {
+				result := rollResult{Name: race.Name}
+				if speeds[i]*unit > race.From {
+					result.Name = fmt.Sprintf("%s (from %.1f %s)", race.Name, speeds[i]*unit, race.Unit)
+				}
+				result.Time, result.Uncertainty, result.Found = timeRoll(race.From/unit, race.To/unit, i, seconds, speeds, throttle, brake)
+				roll.Results = append(roll.Results, result)
+			}
+		}
+		rolls = append(rolls, roll)
+	}
+	return rolls
+}
+
+// Returns the time from the start speed (m/s), or from the launch data point if
+// the car was already going faster, to the end speed (m/s), and its uncertainty,
+// or false if the pull ended before the car got there
+func timeRoll(startSpeed float64, endSpeed float64, launch int, seconds []float64, speeds []float64, throttle []float64, brake []float64) (float64, float64, bool) {
+	start, startError := seconds[launch], timestampError
+	started := speeds[launch] >= startSpeed
+	liftStart := -1
+	for i := launch + 1; i < len(speeds); i++ {
+		if !started && speeds[i-1] < startSpeed && speeds[i] >= startSpeed {
+			start, startError = crossingTime(startSpeed, i, seconds, speeds)
+			started = true
+		}
+		if started && speeds[i-1] < endSpeed && speeds[i] >= endSpeed {
+			end, endError := crossingTime(endSpeed, i, seconds, speeds)
+			return end - start, math.Sqrt(endError*endError + startError*startError), true
+		}
+		if throttle[i] == 0 {
+			if liftStart < 0 {
+				liftStart = i
+			}
+		} else {
+			liftStart = -1
+		}
+		if (brake != nil && brake[i] > 0) || (liftStart >= 0 && (seconds[i]-seconds[liftStart])*1000 >= dragLiftMS) {
+			return 0, 0, false
+		}
+	}
+	return 0, 0, false
+}
+
+// Prints every roll race in the session: where the throttle went down, at what
+// speed and in which gear, and the time to the end speed
+func printRollRaces(session *Session, races []speedInterval) {
+	rolls := calcRollRaces(session, races)
+	if len(rolls) == 0 {
+		fmt.Println("No roll races found in log. Cruise at a steady speed for a second, then floor it.")
+		return
+	}
+	for _, roll := range rolls {
+		fmt.Printf("Floored at %.1f mph in gear %d, %.2f seconds into the log\n", roll.Speed*speedUnits["mph"], roll.Gear, roll.StartTime)
+		if len(roll.Results) == 0 {
+			fmt.Println("  Doesn't match the start speed of any roll race")
+		}
+		for _, result := range roll.Results {
+			if !result.Found {
+				fmt.Printf("  %s: Failed! (lifted before the end speed)\n", result.Name)
+				continue
+			}
+			fmt.Printf("  %s: %.3f ± %.4f s\n", result.Name, result.Time, result.Uncertainty)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCalcRollRaces(t *testing.T) {
+	races, err := parseRollRaces("40-140,60-130")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const accel = 5.0 // m/s² once floored
+	mph := speedUnits["mph"]
+	tests := []struct {
+		name   string
+		cruise float64 // mph before flooring it
+		want   []rollResult
+	}{
+		{"on the start speed", 40, []rollResult{{Name: "40-140 mph", Time: 100 / mph / accel, Found: true}}},
+		{"below the start speed", 36, []rollResult{{Name: "40-140 mph", Time: 100 / mph / accel, Found: true}}},
+		{"above the start speed", 43, []rollResult{{Name: "40-140 mph (from 43.0 mph)", Time: 97 / mph / accel, Found: true}}},
+		{"between races", 50, nil},
+	}
+	for _, test := range tests {
+		// Cruising for 2 seconds, then flooring it for 12
+		session := buildSession([]string{"TimestampMS", "Speed", "Accel"}, 14*60, func(i int) []float64 {
+			seconds := float64(i) / 60
+			throttle := 100.0
+			if seconds >= 2 {
+				throttle = 255
+			}
+			return []float64{seconds * 1000, test.cruise/mph + accel*math.Max(0, seconds-2), throttle}
+		})
+		rolls := calcRollRaces(session, races)
+		if len(rolls) != 1 {
+			t.Errorf("%s: %d rolls, want 1", test.name, len(rolls))
+			continue
+		}
+		if len(rolls[0].Results) != len(test.want) {
+			t.Errorf("%s: results %+v, want %+v", test.name, rolls[0].Results, test.want)
+			continue
+		}
+		for k, result := range rolls[0].Results {
+			want := test.want[k]
+			if result.Name != want.Name || result.Found != want.Found || math.Abs(result.Time-want.Time) > 1e-6 {
+				t.Errorf("%s: %s = %.4f s (found %v), want %s = %.4f s", test.name, result.Name, result.Time, result.Found, want.Name, want.Time)
+			}
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Limits used to split a log into sessions and runs
+const (
+	maxTimeGapMS    = 1000 // A longer gap between packets means logging was paused (menus, loading, another event)
+	teleportMargin  = 10.0 // Meters a car can move beyond what its speed allows before it counts as a teleport (reset to track, new event)
+	stoppedSpeed    = 0.5  // Meters/sec, below this the car is stopped
+	standstillSpeed = 0.05 // Meters/sec, below this the car isn't moving at all (same as calcDragTimes)
+	minStopMS       = 1000 // The car has to be stopped this long for a run to end
+	minSegmentRows  = 30   // Shorter segments (about half a second) are ignored
+)
+
+// A continuous part of a log
+type logSegment struct {
+	Number string
+	Reason string // Why the segment started
+	Data   *Session
+	Runs   []logSegment // The runs within a session (nil for a run)
+}
+
+// Splits a session into sessions for each continuous stint of driving.
+// A new session starts whenever the race starts again after menus (IsRaceOn),
+// the car changes (CarOrdinal), packets stop for a while (TimestampMS) or the car
+// teleports (PositionX/Y/Z). Each session is then split into runs, which start
+// from a stop and end the next time the car stops.
+// Sessions are numbered starting from first.
+func segmentLog(data *Session, first int) []logSegment {
+	if data.Len() < 1 {
+		return nil
+	}
+	raceOn := data.Float("IsRaceOn")
+	car := data.Float("CarOrdinal")
+	t := data.Float("TimestampMS")
+	x, y, z := data.Float("PositionX"), data.Float("PositionY"), data.Float("PositionZ")
+	hasPosition := data.Has("PositionX")
+	s := data.Float("Speed")
+
+	var sessions []logSegment
+	start := -1 // first data point of the current session, -1 when there isn't one
+	reason := "start of log"
+	endSession := func(end int, nextReason string) { // end not included
+		if start >= 0 && end-start >= minSegmentRows {
+			session := logSegment{
+				Number: fmt.Sprint(first + len(sessions)),
+				Reason: reason,
+				Data:   data.Slice(start, end),
+			}
+			session.Runs = splitRuns(session)
+			sessions = append(sessions, session)
+		}
+		start = -1
+		reason = nextReason
+	}
+
+	for i := range t {
+		if raceOn != nil && raceOn[i] != 1 { // In menus or paused
+			if start >= 0 {
+				endSession(i, "race started")
+			}
+			continue
+		}
+		if start >= 0 {
+			prev := i - 1
+			dt := t[i] - t[prev]
+			if car[i] != car[prev] {
+				endSession(i, "car changed")
+			} else if dt > maxTimeGapMS || dt < 0 {
+				endSession(i, fmt.Sprintf("%.0f ms gap in timestamps", dt))
+			} else if hasPosition {
+				moved := math.Sqrt(math.Pow(x[i]-x[prev], 2) + math.Pow(y[i]-y[prev], 2) + math.Pow(z[i]-z[prev], 2))
+				allowed := math.Max(s[i], s[prev])*dt/1000*1.5 + teleportMargin
+				if moved > allowed {
+					endSession(i, fmt.Sprintf("car teleported %.0f m", moved))
+				}
+			}
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	endSession(len(t), "")
+
+	return sessions
+}
+
+// Splits a session into runs. A run starts from the car's last standstill and
+// ends once the car has stopped for at least minStopMS.
+func splitRuns(session logSegment) []logSegment {
+	t := session.Data.Float("TimestampMS")
+	s := session.Data.Float("Speed")
+
+	var runs []logSegment
+	addRun := func(start int, end int) { // end included
+		if end-start+1 < minSegmentRows {
+			return
+		}
+		runs = append(runs, logSegment{
+			Number: fmt.Sprintf("%s.%d", session.Number, len(runs)+1),
+			Reason: "launch",
+			Data:   session.Data.Slice(start, end+1),
+		})
+	}
+
+	start := -1     // first data point of the current run, -1 while stopped
+	stopStart := -1 // first data point of the current stop, -1 while moving
+	for i := range s {
+		if s[i] < stoppedSpeed {
+			if stopStart < 0 {
+				stopStart = i
+			}
+			if start >= 0 && t[i]-t[stopStart] >= minStopMS {
+				// End the run once the car is at a standstill, so stops to 0 mph are included
+				end := stopStart
+				for end < i && s[end] >= standstillSpeed {
+					end++
+				}
+				addRun(start, end)
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			// Start the run from the last moment the car was at a standstill, so launches from 0 mph are included
+			start = i
+			for start > 0 && start > stopStart && s[start] >= standstillSpeed {
+				start--
+			}
+		}
+		stopStart = -1
+	}
+	if start >= 0 {
+		addRun(start, len(s)-1)
+	}
+	return runs
+}
+
+// Splits the parts of a log (see normalizeTime) into sessions and runs, then prints
+// the race results of each session (on the track it was driven on) and the stat
+// line and drag results (at each of the given distances) of each run.
+func printSegments(parts []*Session, timing intervalTiming, distances []dragDistance, tracks trackConfig) {
+	var sessions []logSegment
+	for _, part := range parts {
+		sessions = append(sessions, segmentLog(part, len(sessions)+1)...)
+	}
+	if len(sessions) == 0 {
+		fmt.Println("No sessions found in log.")
+		return
+	}
+
+	for _, session := range sessions {
+		t := session.Data.Float("TimestampMS")
+		fmt.Printf("\nSession %s (%s): car %d, %d data points, %.1f seconds\n",
+			session.Number, session.Reason, session.Data.Frame(0).Int("CarOrdinal"), session.Data.Len(), (t[len(t)-1]-t[0])/1000)
+
+		// Race results only make sense if a lap was finished
+		if laps := session.Data.Float("LapNumber"); laps != nil && laps[len(laps)-1] > laps[0] {
+			if track, err := tracks.find(session.Data); err != nil {
+				fmt.Printf("  %v\n", err)
+			} else {
+				fmt.Printf("  Track: %s\n", track.Name)
+				bestLap, gap, topSpeed, sectors, _ := calcRaceStats(session.Data, track)
+				fmt.Printf("  Best Lap: %s (best valid lap %s s off the theoretical best)  Track Top Speed: %s mph  Sectors: %v\n", bestLap, gap, topSpeed, sectors)
+			}
+		}
+
+		for _, run := range session.Runs {
+			fmt.Printf("  Run %s:\n", run.Number)
+			stats := calcstats(run.Data, timing)
+			for _, name := range stats.Names {
+				fmt.Printf("    %s: %s\n", name, stats.Format(name))
+			}
+			if mass := estimateMass(run.Data); mass.Found {
+				fmt.Printf("    Weight: %.0f ± %.0f lb\n", mass.Mass*kgToLb, mass.Band*kgToLb)
+			}
+			calcDrag(run.Data, distances).print("    ")
+		}
+	}
+}
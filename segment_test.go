@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSegmentLog(t *testing.T) {
+	// Four 4 second stints at 60 data points a second, each with two runs: stopped,
+	// 10 m/s from 0.5 to 1.5 s, stopped, 10 m/s from 2.8 to 3.5 s, stopped
+	const perSession = 240
+	x := 0.0
+	session := buildSession([]string{"TimestampMS", "IsRaceOn", "CarOrdinal", "PositionX", "PositionY", "PositionZ", "Speed"}, 4*perSession, func(i int) []float64 {
+		t := float64(i) * 1000 / 60
+		seconds := float64(i%perSession) / 60
+		speed := 0.0
+		if (seconds >= 0.5 && seconds < 1.5) || (seconds >= 2.8 && seconds < 3.5) {
+			speed = 10
+		}
+		x += speed / 60
+		car := 1.0
+		if i >= perSession { // New car for the second stint
+			car = 2
+		}
+		if i >= 2*perSession { // Logging paused before the third
+			t += 5000
+		}
+		if i == 3*perSession { // Reset to track before the fourth
+			x += 1000
+		}
+		return []float64{t, 1, car, x, 0, 0, speed}
+	})
+
+	sessions := segmentLog(session, 1)
+	reasons := []string{"start of log", "car changed", "5017 ms gap in timestamps", "car teleported 1000 m"}
+	if len(sessions) != len(reasons) {
+		t.Fatalf("%d sessions, want %d", len(sessions), len(reasons))
+	}
+	for k, s := range sessions {
+		if s.Reason != reasons[k] || s.Data.Len() != perSession {
+			t.Errorf("session %s: %q with %d data points, want %q with %d", s.Number, s.Reason, s.Data.Len(), reasons[k], perSession)
+		}
+		if len(s.Runs) != 2 {
+			t.Errorf("session %s: %d runs, want 2", s.Number, len(s.Runs))
+			continue
+		}
+		for j, run := range s.Runs {
+			speeds := run.Data.Float("Speed")
+			if !strings.HasPrefix(run.Number, s.Number+".") || speeds[0] != 0 || speeds[1] != 10 {
+				t.Errorf("run %s starts at %v, want a run of session %s from a standstill", run.Number, speeds[:2], s.Number)
+			}
+			if j == 0 && speeds[len(speeds)-1] != 0 {
+				t.Errorf("run %s doesn't end at a standstill", run.Number)
+			}
+		}
+	}
+
+	// Menus end a session, and too few data points aren't one
+	raceOn := session.Float("IsRaceOn")
+	for i := perSession - 10; i < perSession+perSession-20; i++ {
+		raceOn[i] = 0
+	}
+	sessions = segmentLog(session, 1)
+	if len(sessions) != 3 || sessions[1].Reason != "5017 ms gap in timestamps" {
+		t.Errorf("with menus, %d sessions, want 3 without the second stint", len(sessions))
+	}
+}
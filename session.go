@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A telemetry log loaded into named channels, one per CSV column (or packet field).
+// Every calculation shares the same parsed copy of the data, and looks up
+// channels by the same names as the CSV headers.
+type Session struct {
+	Format   *PacketFormat // Packet format (and so the game) the log was recorded with
+	Columns  []string      // Channel names, in CSV column order
+	channels map[string][]float64
+	length   int
+}
+
+// A single data point of a session
+type Frame struct {
+	Session *Session
+	Index   int
+}
+
+// Creates an empty session with the given channels
+func newSession(format *PacketFormat, columns []string) *Session {
+	s := &Session{Format: format, channels: make(map[string][]float64)}
+	for _, name := range columns {
+		if _, isPresent := s.channels[name]; isPresent {
+			continue
+		}
+		s.Columns = append(s.Columns, name)
+		s.channels[name] = nil
+	}
+	return s
+}
+
+// Loads a CSV log into a session, recorded with the given packet format (nil to
+// work it out from the log). Returns an error if the file can't be read, a value
+// isn't a number, the game can't be worked out, or the log is missing the
+// columns every calculation needs.
+func loadSession(name string, format *PacketFormat) (*Session, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open '%s': %s", name, err.Error())
+	}
+	defer f.Close()
+
+	// Counting the lines first is much faster than parsing them, and means each
+	// channel can be allocated once at the right size instead of growing row by row
+	lines, err := countLines(f)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read '%s': %s", name, err.Error())
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("Cannot read '%s': %s", name, err.Error())
+	}
+	return readSession(name, f, lines-1, format)
+}
+
+// Returns the number of lines in a file
+func countLines(r io.Reader) (int, error) {
+	buf := make([]byte, 1<<16)
+	lines := 0
+	for {
+		n, err := r.Read(buf)
+		lines += bytes.Count(buf[:n], []byte{'\n'})
+		if err == io.EOF {
+			return lines, nil
+		} else if err != nil {
+			return lines, err
+		}
+	}
+}
+
+// Reads a CSV log into a session one row at a time, so only the parsed values
+// are kept in memory (an hour of driving is over 200,000 rows). sizeHint is the
+// expected number of rows, used to allocate the channels up front. format is the
+// packet format the log was recorded with, or nil to work it out from the log.
+func readSession(name string, r io.Reader, sizeHint int, format *PacketFormat) (*Session, error) {
+	reader := csv.NewReader(bufio.NewReaderSize(r, 1<<16))
+	reader.ReuseRecord = true // Each row is parsed straight into the channels, so the strings aren't kept
+
+	record, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("'%s' has no data", name)
+	} else if err != nil {
+		return nil, fmt.Errorf("Cannot read CSV data: %s", err.Error())
+	}
+	header := append([]string(nil), record...)
+
+	// The PacketFormat column written by Listen Mode is text, every other column is a number
+	formatCol := -1
+	var columns []string
+	for k, v := range header {
+		if v == formatColumn {
+			formatCol = k
+		} else {
+			columns = append(columns, v)
+		}
+	}
+	s := newSession(nil, columns)
+	s.reserve(sizeHint)
+
+	tag := ""
+	values := make([]float64, 0, len(header))
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Cannot read CSV data: %s", err.Error())
+		}
+		values = values[:0]
+		for k, v := range record {
+			if k == formatCol {
+				if tag == "" {
+					tag = v
+				}
+				continue
+			}
+			value, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s line %d: %s is not a number (%q)", name, line, header[k], v)
+			}
+			values = append(values, value)
+		}
+		s.appendValues(values)
+	}
+	if s.Len() == 0 {
+		return nil, fmt.Errorf("'%s' has no data", name)
+	}
+
+	s.Format = format
+	if s.Format == nil {
+		if s.Format, err = logPacketFormat(s, tag); err != nil {
+			return nil, fmt.Errorf("'%s' %v", name, err)
+		}
+	}
+	s.addDerivedChannels()
+	if err := s.Require("TimestampMS", "Speed", "CarOrdinal"); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return s, nil
+}
+
+// Returns the packet format (and so the game) a log was recorded with.
+// Logs written by Listen Mode have a PacketFormat column. Older logs (from fdt) are
+// matched by the columns they have. Forza Horizon and FM7 dash logs only differ by
+// Forza Horizon's padding, which has no columns, so an untagged dash log could be
+// either and is an error: the game has to be given with -format.
+func logPacketFormat(s *Session, tag string) (*PacketFormat, error) {
+	if format, err := getPacketFormat(tag); err == nil {
+		return format, nil
+	}
+	if s.Has("TrackOrdinal") {
+		return packetFormats["fm8"], nil
+	} else if s.Has("Speed") {
+		return nil, fmt.Errorf("is an unknown dash format log (it doesn't say which game it's from), use -format fh for Forza Horizon or -format fm7dash for Forza Motorsport 7")
+	}
+	return packetFormats["fm7sled"], nil
+}
+
+// Adds channels that the packet format doesn't send but can be worked out from
+// other channels. The FM7 "sled" format has no Speed, so it's calculated from the
+// car's velocity.
+func (s *Session) addDerivedChannels() {
+	if s.Has("Speed") || !s.Has("VelocityX") {
+		return
+	}
+	vx, vy, vz := s.Float("VelocityX"), s.Float("VelocityY"), s.Float("VelocityZ")
+	speed := make([]float64, s.Len())
+	for i := range speed {
+		speed[i] = math.Sqrt(vx[i]*vx[i] + vy[i]*vy[i] + vz[i]*vz[i])
+	}
+	s.setChannel("Speed", speed)
+}
+
+// Makes room for n more data points without growing the channels again
+func (s *Session) reserve(n int) {
+	if n <= 0 {
+		return
+	}
+	for name, values := range s.channels {
+		grown := make([]float64, len(values), len(values)+n)
+		copy(grown, values)
+		s.channels[name] = grown
+	}
+}
+
+// Adds a data point. Values must be in the same order as Columns.
+func (s *Session) appendValues(values []float64) {
+	for k, name := range s.Columns {
+		s.channels[name] = append(s.channels[name], values[k])
+	}
+	s.length++
+}
+
+// Adds a decoded packet as a data point, matching fields to channels by name
+func (s *Session) appendPacket(p Packet) {
+	for _, name := range s.Columns {
+		v, _ := p.Get(name)
+		s.channels[name] = append(s.channels[name], v)
+	}
+	s.length++
+}
+
+// Returns the number of data points
+func (s *Session) Len() int {
+	return s.length
+}
+
+// Returns true if the session has the named channel
+func (s *Session) Has(name string) bool {
+	_, isPresent := s.channels[name]
+	return isPresent
+}
+
+// Returns an error naming every channel in the list that the session doesn't have
+func (s *Session) Require(names ...string) error {
+	var missing []string
+	for _, name := range names {
+		if !s.Has(name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Log is missing required columns: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Returns every value of the named channel (nil if the session doesn't have it).
+// The slice is shared with the session, so it must not be modified.
+func (s *Session) Float(name string) []float64 {
+	return s.channels[name]
+}
+
+// Returns every value of the named channel as integers (nil if the session doesn't have it)
+func (s *Session) Int(name string) []int {
+	values, isPresent := s.channels[name]
+	if !isPresent {
+		return nil
+	}
+	ints := make([]int, len(values))
+	for i, v := range values {
+		ints[i] = int(v)
+	}
+	return ints
+}
+
+// Returns the data point at index i
+func (s *Session) Frame(i int) Frame {
+	return Frame{Session: s, Index: i}
+}
+
+// Returns the value of the named channel at this data point
+func (f Frame) Float(name string) float64 {
+	return f.Session.channels[name][f.Index]
+}
+
+// Returns the value of the named channel at this data point as an integer
+func (f Frame) Int(name string) int {
+	return int(f.Session.channels[name][f.Index])
+}
+
+// Returns the data points from start up to (not including) end.
+// The new session shares its data with this one.
+func (s *Session) Slice(start int, end int) *Session {
+	sub := &Session{Format: s.Format, Columns: s.Columns, channels: make(map[string][]float64), length: end - start}
+	for name, values := range s.channels {
+		sub.channels[name] = values[start:end:end]
+	}
+	return sub
+}
+
+// Returns a copy of the session with only the data points at the given indexes
+func (s *Session) Select(indexes []int) *Session {
+	sub := &Session{Format: s.Format, Columns: s.Columns, channels: make(map[string][]float64), length: len(indexes)}
+	for name, values := range s.channels {
+		selected := make([]float64, len(indexes))
+		for k, i := range indexes {
+			selected[k] = values[i]
+		}
+		sub.channels[name] = selected
+	}
+	return sub
+}
+
+// Replaces the values of the named channel. Values must have one value per data point.
+func (s *Session) setChannel(name string, values []float64) {
+	if !s.Has(name) { // Copy the names first, since sliced sessions share them
+		s.Columns = append(append([]string(nil), s.Columns...), name)
+	}
+	s.channels[name] = values
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadSession(t *testing.T) {
+	tests := []struct {
+		name   string
+		csv    string
+		forced string // Format given with -format, "" for none
+		format string // Format name the log should be detected as
+		speeds []float64
+		err    string // Part of the error, "" for none
+	}{
+		{
+			name:   "tagged",
+			csv:    "TimestampMS,Speed,CarOrdinal,PacketFormat\n0,1.5,12,fm7dash\n16,2,12,fm7dash\n",
+			format: "fm7dash",
+			speeds: []float64{1.5, 2},
+		},
+		{
+			name:   "forza motorsport",
+			csv:    "TimestampMS,Speed,CarOrdinal,TrackOrdinal\n0,1,12,3\n",
+			format: "fm8",
+			speeds: []float64{1},
+		},
+		{
+			name: "untagged dash",
+			csv:  "TimestampMS,Speed,CarOrdinal\n0,1,12\n",
+			err:  "unknown dash format",
+		},
+		{
+			name:   "untagged dash with -format",
+			csv:    "TimestampMS,Speed,CarOrdinal\n0,1,12\n",
+			forced: "fm7dash",
+			format: "fm7dash",
+			speeds: []float64{1},
+		},
+		{
+			name:   "-format over the tag",
+			csv:    "TimestampMS,Speed,CarOrdinal,PacketFormat\n0,1,12,fh\n",
+			forced: "fm7dash",
+			format: "fm7dash",
+			speeds: []float64{1},
+		},
+		{
+			name:   "sled speed from velocity",
+			csv:    "TimestampMS,CarOrdinal,VelocityX,VelocityY,VelocityZ\n0,12,3,0,4\n",
+			format: "fm7sled",
+			speeds: []float64{5},
+		},
+		{
+			name: "not a number",
+			csv:  "TimestampMS,Speed,CarOrdinal,PacketFormat\n0,fast,12,fh\n",
+			err:  "line 2: Speed is not a number",
+		},
+		{
+			name: "missing column",
+			csv:  "TimestampMS,Speed,PacketFormat\n0,1,fh\n",
+			err:  "missing required columns: CarOrdinal",
+		},
+		{
+			name: "empty",
+			csv:  "TimestampMS,Speed,CarOrdinal\n",
+			err:  "has no data",
+		},
+	}
+	for _, test := range tests {
+		var format *PacketFormat
+		if test.forced != "" {
+			format = packetFormats[test.forced]
+		}
+		s, err := readSession("log.csv", strings.NewReader(test.csv), 0, format)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, want one containing %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if s.Format.Name != test.format {
+			t.Errorf("%s: format %s, want %s", test.name, s.Format.Name, test.format)
+		}
+		speeds := s.Float("Speed")
+		if len(speeds) != len(test.speeds) {
+			t.Errorf("%s: Speed = %v, want %v", test.name, speeds, test.speeds)
+			continue
+		}
+		for i := range speeds {
+			if speeds[i] != test.speeds[i] {
+				t.Errorf("%s: Speed = %v, want %v", test.name, speeds, test.speeds)
+				break
+			}
+		}
+	}
+}
+
+func TestSessionSelect(t *testing.T) {
+	s := buildSession([]string{"TimestampMS", "DistanceTraveled"}, 4, func(i int) []float64 { return []float64{float64(16 * i), float64(i)} })
+	sub := s.Select([]int{1, 3})
+	if sub.Len() != 2 || sub.Float("DistanceTraveled")[1] != 3 {
+		t.Errorf("Select = %v, want [1 3]", sub.Float("DistanceTraveled"))
+	}
+	sub.setChannel("Speed", []float64{5, 6})
+	if s.Has("Speed") {
+		t.Errorf("setChannel on a selection added Speed to the original session")
+	}
+}
+
+// Builds an "fh" session of n data points, with row returning the values of data point i
+func buildSession(columns []string, n int, row func(i int) []float64) *Session {
+	s := newSession(packetFormats["fh"], columns)
+	for i := 0; i < n; i++ {
+		s.appendValues(row(i))
+	}
+	return s
+}
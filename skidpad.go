@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Limits used to find steady-state cornering in a skidpad run
+const (
+	skidpadSpeedTolerance = 5.0  // mph either side of the target speed
+	skidpadWindowMS       = 2000 // The car has to hold the corner this long
+	skidpadMinG           = 0.3  // Less lateral G than this isn't cornering
+	skidpadMaxStdDev      = 0.05 // g, more variation than this within the window isn't steady
+	skidpadMaxYawError    = 0.15 // Fraction lateral G can differ from speed × yaw rate (what a steady circle gives)
+	skidpadTrim           = 0.1  // Fraction of a window's data points with the most and the least lateral G left out
+)
+
+// Speeds (mph) the stat line has lateral G columns for
+var skidpadSpeeds = []float64{60, 120}
+
+// The best steady-state cornering found near one speed
+type skidpadResult struct {
+	Speed     float64 // Target speed in mph
+	LateralG  float64 // Average lateral G over the window
+	StartTime float64 // Seconds since the start of the log
+	Duration  float64 // Seconds
+	Found     bool
+}
+
+// Finds the time window near the target speed (mph) where the car held the
+// most lateral G steadily for at least skidpadWindowMS. A window is steady when
+// the car's speed stays within skidpadSpeedTolerance, its lateral G
+// (AccelerationX) barely varies, and the lateral G matches speed times yaw rate
+// (AngularVelocityY), as it does when driving a constant circle. The data points
+// with the most and least lateral G (skidpadTrim of each) are left out of the
+// window first, so short spikes (kerbs, bumps, snap oversteer) are ignored.
+func calcSkidpad(session *Session, targetSpeed float64) skidpadResult {
+	result := skidpadResult{Speed: targetSpeed}
+	if session.Require("TimestampMS", "Speed", "AccelerationX", "AngularVelocityY") != nil {
+		return result
+	}
+	t := session.Float("TimestampMS")
+	speeds := session.Float("Speed")
+	lateral := session.Float("AccelerationX")
+	yawRate := session.Float("AngularVelocityY")
+
+	// Find the runs of data points near the target speed: runEnd is the last data
+	// point of the run each one is in, -1 if it isn't in one
+	runEnd := make([]int, len(t))
+	for i := len(t) - 1; i >= 0; i-- {
+		runEnd[i] = -1
+		if math.Abs(speeds[i]*speedUnits["mph"]-targetSpeed) <= skidpadSpeedTolerance {
+			runEnd[i] = i
+			if i+1 < len(t) && runEnd[i+1] >= 0 {
+				runEnd[i] = runEnd[i+1]
+			}
+		}
+	}
+
+	end := 0         // first data point at least skidpadWindowMS after start
+	var window []int // data points of the window, by lateral G
+	for start := range t {
+		for end < len(t) && t[end]-t[start] < skidpadWindowMS {
+			end++
+		}
+		if end >= len(t) {
+			break
+		}
+		if runEnd[start] < end {
+			continue // The car left the target speed too soon
+		}
+
+		window = window[:0]
+		for i := start; i <= end; i++ {
+			window = append(window, i)
+		}
+		sort.Slice(window, func(a, b int) bool { return math.Abs(lateral[window[a]]) < math.Abs(lateral[window[b]]) })
+		trim := int(float64(len(window)) * skidpadTrim)
+		kept := window[trim : len(window)-trim]
+
+		var total, totalSquares, totalYaw float64
+		sameDirection := true
+		for _, i := range kept {
+			g := math.Abs(lateral[i]) / gravity
+			total += g
+			totalSquares += g * g
+			totalYaw += math.Abs(speeds[i]*yawRate[i]) / gravity
+			if (lateral[i] > 0) != (lateral[kept[0]] > 0) {
+				sameDirection = false
+			}
+		}
+		n := float64(len(kept))
+		mean := total / n
+		stdDev := math.Sqrt(math.Max(0, totalSquares/n-mean*mean))
+		yawError := math.Abs(totalYaw/n-mean) / mean
+		if mean < skidpadMinG || !sameDirection || stdDev > skidpadMaxStdDev || yawError > skidpadMaxYawError {
+			continue
+		}
+		if mean > result.LateralG {
+			result.LateralG = mean
+			result.StartTime = (t[start] - t[0]) / 1000
+			result.Duration = (t[end] - t[start]) / 1000
+			result.Found = true
+		}
+	}
+	return result
+}
+
+// Returns the sustained lateral G at each of skidpadSpeeds, formatted for the stat line ("" if not found)
+func skidpadStats(session *Session) []string {
+	var stats []string
+	for _, speed := range skidpadSpeeds {
+		result := calcSkidpad(session, speed)
+		if result.Found {
+			stats = append(stats, strconv.FormatFloat(result.LateralG, 'f', 2, 32))
+		} else {
+			stats = append(stats, "")
+		}
+	}
+	return stats
+}
+
+// Prints the sustained lateral G found at each of skidpadSpeeds
+func printSkidpad(session *Session) {
+	if err := session.Require("AccelerationX", "AngularVelocityY"); err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, speed := range skidpadSpeeds {
+		result := calcSkidpad(session, speed)
+		if !result.Found {
+			fmt.Printf("Lateral G at %.0f mph: no steady cornering found within %.0f mph\n", speed, skidpadSpeedTolerance)
+			continue
+		}
+		fmt.Printf("Lateral G at %.0f mph: %.2f g (held for %.1f seconds from %.1f seconds)\n",
+			speed, result.LateralG, result.Duration, result.StartTime)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCalcSkidpad(t *testing.T) {
+	// The car circling at a steady speed and lateral G, with yawScale times the yaw
+	// rate a steady circle needs, and a spike of lateral G at 1 second
+	tests := []struct {
+		name     string
+		mph      float64
+		g        float64
+		seconds  float64
+		yawScale float64
+		spike    float64
+		speed    float64 // Skidpad speed timed, mph
+		want     float64
+		found    bool
+	}{
+		{"steady circle", 60, 0.95, 3, 1, 0, 60, 0.95, true},
+		{"within the speed tolerance", 63, 0.95, 3, 1, 0, 60, 0.95, true},
+		{"wrong speed", 60, 0.95, 3, 1, 0, 120, 0, false},
+		{"too short", 60, 0.95, 1.5, 1, 0, 60, 0, false},
+		{"sliding, yaw doesn't match", 60, 0.95, 3, 0.5, 0, 60, 0, false},
+		{"not cornering", 60, 0.1, 3, 1, 0, 60, 0, false},
+		// A kerb strike is one data point of every 2 second window, left out as an outlier
+		{"spike", 60, 0.95, 3, 1, 1.5, 60, 0.95, true},
+		{"dip", 60, 0.95, 3, 1, -0.9, 60, 0.95, true},
+	}
+	for _, test := range tests {
+		speed := test.mph / speedUnits["mph"]
+		session := buildSession([]string{"TimestampMS", "Speed", "AccelerationX", "AngularVelocityY"}, int(test.seconds*60), func(i int) []float64 {
+			lateral := test.g
+			if i == 60 {
+				lateral += test.spike
+			}
+			return []float64{float64(i) * 1000 / 60, speed, lateral * gravity, test.yawScale * test.g * gravity / speed}
+		})
+		result := calcSkidpad(session, test.speed)
+		if result.Found != test.found || math.Abs(result.LateralG-test.want) > 1e-9 {
+			t.Errorf("%s: calcSkidpad = %.3f g (found %v), want %.3f g (found %v)", test.name, result.LateralG, result.Found, test.want, test.found)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Limits used to tell rewinds apart from a new race or a restarted game
+const (
+	rewindDistance = 1.0   // Meters DistanceTraveled has to go backwards by to count as a rewind
+	maxRewindMS    = 60000 // Rewinds never go back further than this, anything longer is a restart
+)
+
+// Makes TimestampMS safe for calculations that assume time only goes forward.
+//
+// TimestampMS is a u32 that wraps around to 0 (about every 49.7 days), so it's
+// unwrapped into a continuous time base. Rewinding in game makes DistanceTraveled
+// (and sometimes the timestamp) jump backwards. In "splice" mode the data points
+// that were rewound over are removed and the time after the rewind is shifted so
+// there is no gap. In "split" mode the log is split into separate parts at each
+// rewind instead.
+//
+// Returns the log parts (splice mode only splits when the game restarted its
+// clock) and a description of every fix made.
+func normalizeTime(data *Session, mode string) ([]*Session, []string) {
+	if mode != "splice" && mode != "split" {
+		log.Fatalf("Unknown rewind mode '%s' (expected splice or split)", mode)
+	}
+	if data.Len() < 1 {
+		return []*Session{data}, nil
+	}
+	raw := data.Float("TimestampMS")
+	distance := data.Float("DistanceTraveled") // nil for the FM7 "sled" format
+	raceOn := data.Float("IsRaceOn")
+
+	var fixes []string
+	var parts []*Session
+	var keep []int      // data points in the current part
+	var times []float64 // normalized times of the current part
+	newPart := func() {
+		part := data.Select(keep)
+		part.setChannel("TimestampMS", times)
+		parts = append(parts, part)
+		keep, times = nil, nil
+	}
+
+	wrapOffset := 0.0   // added to TimestampMS for each time it wrapped around
+	spliceOffset := 0.0 // taken off TimestampMS for the time removed by splicing out rewinds
+	for i := range raw {
+		line := i + 2 // line number in the CSV file, for reporting
+
+		if i > 0 && raw[i-1]-raw[i] > 1<<31 {
+			wrapOffset += 1 << 32
+			fixes = append(fixes, fmt.Sprintf("Line %d: TimestampMS wrapped around to 0, unwrapped it", line))
+		}
+		t := raw[i] + wrapOffset - spliceOffset
+
+		if n := len(times); n > 0 {
+			last := keep[n-1]
+			rewound := 0 // number of data points that were rewound over
+			if t < times[n-1] {
+				for rewound < n && times[n-1-rewound] >= t {
+					rewound++
+				}
+			} else if distance != nil && (raceOn == nil || (raceOn[i] == 1 && raceOn[last] == 1)) && distance[i] < distance[last]-rewindDistance {
+				for rewound < n && distance[keep[n-1-rewound]] > distance[i] {
+					rewound++
+				}
+			}
+
+			if rewound > 0 && (rewound == n || times[n-1]-times[n-rewound] > maxRewindMS) {
+				// Went back too far to be a rewind: a new race or the game restarting its clock
+				if t < times[n-1] {
+					fixes = append(fixes, fmt.Sprintf("Line %d: TimestampMS restarted, split the log", line))
+					newPart()
+				}
+			} else if rewound > 0 {
+				rewindTime := (times[n-1] - times[n-rewound]) / 1000
+				if mode == "split" {
+					fixes = append(fixes, fmt.Sprintf("Line %d: rewind of %.2f seconds, split the log", line, rewindTime))
+					newPart()
+				} else {
+					fixes = append(fixes, fmt.Sprintf("Line %d: rewind of %.2f seconds, removed %d data points", line, rewindTime, rewound))
+					keep, times = keep[:n-rewound], times[:n-rewound]
+					if m := len(times); m > 0 && t > times[m-1] {
+						// The clock kept going during the rewind, so close the gap it left.
+						// Only ever take time out: if the gap is already shorter than a step,
+						// adding to it would shift the rest of the log later.
+						step := 16.0 // ms, about one packet at 60 packets per second
+						if m > 1 {
+							step = times[m-1] - times[m-2]
+						}
+						if gap := t - times[m-1] - step; gap > 0 {
+							spliceOffset += gap
+							t -= gap
+						}
+					}
+				}
+			}
+		}
+
+		keep = append(keep, i)
+		times = append(times, t)
+	}
+	newPart()
+	return parts, fixes
+}
+
+// Returns the part of a log with the most data points
+func longestPart(parts []*Session) *Session {
+	longest := parts[0]
+	for _, part := range parts {
+		if part.Len() > longest.Len() {
+			longest = part
+		}
+	}
+	return longest
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeTime(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		times     []float64
+		distances []float64
+		want      [][]float64 // TimestampMS of each part
+		wantDist  [][]float64 // DistanceTraveled of each part (nil to skip)
+		fixes     int
+	}{
+		{
+			name:  "in order",
+			mode:  "splice",
+			times: []float64{0, 16, 32},
+			want:  [][]float64{{0, 16, 32}},
+		},
+		{
+			name:  "wraps around",
+			mode:  "splice",
+			times: []float64{1<<32 - 16, 0, 16},
+			want:  [][]float64{{1<<32 - 16, 1 << 32, 1<<32 + 16}},
+			fixes: 1,
+		},
+		{
+			name:      "rewind spliced out",
+			mode:      "splice",
+			times:     []float64{0, 16, 32, 48, 64, 80},
+			distances: []float64{0, 10, 20, 30, 15, 25},
+			want:      [][]float64{{0, 16, 32, 48}},
+			wantDist:  [][]float64{{0, 10, 15, 25}},
+			fixes:     1,
+		},
+		{
+			// The clock only moved 8 ms past the last kept data point, less than a step
+			name:      "rewind shorter than a step",
+			mode:      "splice",
+			times:     []float64{0, 1000, 1016, 1032, 1040, 1056},
+			distances: []float64{0, 10, 20, 30, 15, 25},
+			want:      [][]float64{{0, 1000, 1040, 1056}},
+			wantDist:  [][]float64{{0, 10, 15, 25}},
+			fixes:     1,
+		},
+		{
+			name:      "rewind split",
+			mode:      "split",
+			times:     []float64{0, 16, 32, 48, 64, 80},
+			distances: []float64{0, 10, 20, 30, 15, 25},
+			want:      [][]float64{{0, 16, 32, 48}, {64, 80}},
+			wantDist:  [][]float64{{0, 10, 20, 30}, {15, 25}},
+			fixes:     1,
+		},
+		{
+			name:  "clock restarted",
+			mode:  "splice",
+			times: []float64{1000, 1016, 1032, 0, 16},
+			want:  [][]float64{{1000, 1016, 1032}, {0, 16}},
+			fixes: 1,
+		},
+	}
+	for _, test := range tests {
+		columns := []string{"TimestampMS"}
+		if test.distances != nil {
+			columns = append(columns, "DistanceTraveled")
+		}
+		session := buildSession(columns, len(test.times), func(i int) []float64 {
+			if test.distances == nil {
+				return []float64{test.times[i]}
+			}
+			return []float64{test.times[i], test.distances[i]}
+		})
+		parts, fixes := normalizeTime(session, test.mode)
+		if len(fixes) != test.fixes {
+			t.Errorf("%s: %d fixes %q, want %d", test.name, len(fixes), fixes, test.fixes)
+		}
+		if len(parts) != len(test.want) {
+			t.Errorf("%s: %d parts, want %d", test.name, len(parts), len(test.want))
+			continue
+		}
+		for k, part := range parts {
+			if got := part.Float("TimestampMS"); !reflect.DeepEqual(got, test.want[k]) {
+				t.Errorf("%s: part %d TimestampMS = %v, want %v", test.name, k, got, test.want[k])
+			}
+			if test.wantDist == nil {
+				continue
+			}
+			if got := part.Float("DistanceTraveled"); !reflect.DeepEqual(got, test.wantDist[k]) {
+				t.Errorf("%s: part %d DistanceTraveled = %v, want %v", test.name, k, got, test.wantDist[k])
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A circuit's lap and sectors, loaded from a JSON file in the tracks directory
+type trackDefinition struct {
+	Key          string       `json:"-"`               // File name without .json, also accepted by -track
+	Name         string       `json:"name"`            // e.g. "La Selva Circuit"
+	Ordinals     []int        `json:"ordinals"`        // Forza Motorsport (2023) TrackOrdinal values of this layout (optional)
+	Length       float64      `json:"length"`          // Lap length in meters
+	Sectors      []float64    `json:"sectors"`         // Meters from the start line where each sector ends, except the last (which ends at the finish line)
+	FinishOffset float64      `json:"finishOffset"`    // Seconds the game adds to the lap time when crossing the finish line
+	Gates        []timingGate `json:"gates,omitempty"` // Timing gates for micro-sectors, in the order they're driven through (optional). They also end the sectors, in place of Sectors
+}
+
+// Used when there's no tracks directory, so Race Mode works as it always has
+var defaultTrack = trackDefinition{
+	Key:          "la_selva",
+	Name:         "La Selva Circuit",
+	Length:       5951,
+	Sectors:      []float64{1878, 3184, 4311},
+	FinishOffset: 0.0125,
+}
+
+// The track definitions, and the track chosen with -track ("" to pick by TrackOrdinal)
+type trackConfig struct {
+	Tracks []trackDefinition
+	Name   string
+}
+
+// Loads every track definition (*.json) in a directory.
+// Uses defaultTrack if the directory doesn't exist.
+func loadTracks(dir string) ([]trackDefinition, error) {
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return []trackDefinition{defaultTrack}, nil
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var tracks []trackDefinition
+	for _, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read '%s': %s", name, err.Error())
+		}
+		var track trackDefinition
+		if err := json.Unmarshal(b, &track); err != nil {
+			return nil, fmt.Errorf("Cannot parse '%s': %s", name, err.Error())
+		}
+		track.Key = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+		if track.Name == "" {
+			track.Name = track.Key
+		}
+		if err := track.check(); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		tracks = append(tracks, track)
+	}
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("No track definitions (*.json) found in '%s'", dir)
+	}
+	return tracks, nil
+}
+
+// Checks the lap length and sectors make sense
+func (track trackDefinition) check() error {
+	if track.Length <= 0 {
+		return fmt.Errorf("Track '%s' needs a lap length of more than 0", track.Name)
+	}
+	last := 0.0
+	for _, end := range track.Sectors {
+		if end <= last || end >= track.Length {
+			return fmt.Errorf("Track '%s' sector ends must be in order, between 0 and the lap length", track.Name)
+		}
+		last = end
+	}
+	if len(track.Gates) > 0 && len(track.Sectors) > 0 {
+		return fmt.Errorf("Track '%s' sectors end at either distances (\"sectors\") or timing gates, not both", track.Name)
+	}
+	if len(track.Gates) == 1 {
+		return fmt.Errorf("Track '%s' needs at least two timing gates for micro-sectors", track.Name)
+	}
+	for k, gate := range track.Gates {
+		if gate.X1 == gate.X2 && gate.Z1 == gate.Z2 {
+			return fmt.Errorf("Track '%s' timing gate %d has both ends in the same place", track.Name, k+1)
+		}
+	}
+	return nil
+}
+
+// Returns the number of sectors, including the last one to the finish line
+func (track trackDefinition) SectorCount() int {
+	if len(track.Gates) > 0 {
+		return len(track.Gates) + 1
+	}
+	return len(track.Sectors) + 1
+}
+
+// Returns the track a session was driven on: the one named with -track (by name or
+// file name), or the one with the log's last TrackOrdinal (only Forza Motorsport
+// (2023) sends it), which is an error if no track file lists it. Logs without a
+// TrackOrdinal use La Selva Circuit if there's a definition for it, or the only
+// track if there's just one.
+func (config trackConfig) find(session *Session) (trackDefinition, error) {
+	if config.Name != "" {
+		for _, track := range config.Tracks {
+			if strings.EqualFold(track.Name, config.Name) || strings.EqualFold(track.Key, config.Name) {
+				return track, nil
+			}
+		}
+		return trackDefinition{}, fmt.Errorf("No track definition named '%s'", config.Name)
+	}
+	if session.Has("TrackOrdinal") {
+		tracks := session.Int("TrackOrdinal")
+		ordinal := tracks[len(tracks)-1]
+		for _, track := range config.Tracks {
+			for _, v := range track.Ordinals {
+				if v == ordinal {
+					return track, nil
+				}
+			}
+		}
+		return trackDefinition{}, fmt.Errorf("No track definition for TrackOrdinal %d, add its \"ordinals\" to a track file or use -track", ordinal)
+	}
+	for _, track := range config.Tracks {
+		if track.Name == defaultTrack.Name {
+			return track, nil
+		}
+	}
+	if len(config.Tracks) == 1 {
+		return config.Tracks[0], nil
+	}
+	return trackDefinition{}, fmt.Errorf("%s doesn't send the track, choose one with -track", session.Format.Game)
+}
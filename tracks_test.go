@@ -0,0 +1,79 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTracks(t *testing.T) {
+	tracks, err := loadTracks(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || len(tracks) != 1 || tracks[0].Name != defaultTrack.Name {
+		t.Errorf("loadTracks of a missing directory = %v (%v), want the default track", tracks, err)
+	}
+	if _, err := loadTracks(t.TempDir()); err == nil {
+		t.Errorf("loadTracks of an empty directory didn't fail")
+	}
+	tracks, err = loadTracks("tracks")
+	if err != nil || len(tracks) == 0 || tracks[0].Key != "la_selva" {
+		t.Errorf("loadTracks(tracks) = %v (%v), want la_selva first", tracks, err)
+	}
+}
+
+func TestTrackCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		track trackDefinition
+		err   string // Part of the error, "" for none
+	}{
+		{"default", defaultTrack, ""},
+		{"no length", trackDefinition{Name: "a"}, "lap length"},
+		{"sectors out of order", trackDefinition{Name: "a", Length: 100, Sectors: []float64{50, 40}}, "in order"},
+		{"sector past the finish", trackDefinition{Name: "a", Length: 100, Sectors: []float64{100}}, "in order"},
+		{"one gate", trackDefinition{Name: "a", Length: 100, Gates: []timingGate{{X2: 1}}}, "two timing gates"},
+		{"sectors and gates", trackDefinition{Name: "a", Length: 100, Sectors: []float64{50}, Gates: []timingGate{{X2: 1}, {X2: 2}}}, "not both"},
+		{"gate with no width", trackDefinition{Name: "a", Length: 100, Gates: []timingGate{{X2: 1}, {X1: 1, X2: 1}}}, "same place"},
+	}
+	for _, test := range tests {
+		err := test.track.check()
+		if (err == nil) != (test.err == "") || (err != nil && !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: check() = %v, want an error containing %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestTrackFind(t *testing.T) {
+	other := trackDefinition{Key: "other", Name: "Other Circuit", Length: 1000, Ordinals: []int{7}}
+	withOrdinal := func(ordinal float64) *Session {
+		return buildSession([]string{"TimestampMS", "TrackOrdinal"}, 2, func(i int) []float64 { return []float64{float64(i), ordinal} })
+	}
+	noOrdinal := buildSession([]string{"TimestampMS"}, 2, func(i int) []float64 { return []float64{float64(16 * i)} })
+	tests := []struct {
+		name    string
+		config  trackConfig
+		session *Session
+		want    string // Key of the track found, "" for an error
+	}{
+		{"by name", trackConfig{[]trackDefinition{defaultTrack, other}, "other circuit"}, noOrdinal, "other"},
+		{"by key", trackConfig{[]trackDefinition{defaultTrack, other}, "OTHER"}, noOrdinal, "other"},
+		{"unknown name", trackConfig{[]trackDefinition{defaultTrack, other}, "nowhere"}, noOrdinal, ""},
+		{"by ordinal", trackConfig{[]trackDefinition{defaultTrack, other}, ""}, withOrdinal(7), "other"},
+		{"unlisted ordinal", trackConfig{[]trackDefinition{defaultTrack, other}, ""}, withOrdinal(3), ""},
+		{"unlisted ordinal, one track", trackConfig{[]trackDefinition{other}, ""}, withOrdinal(3), ""},
+		{"unlisted ordinal, no la selva", trackConfig{[]trackDefinition{other, {Key: "third"}}, ""}, withOrdinal(3), ""},
+		{"no ordinal", trackConfig{[]trackDefinition{other, defaultTrack}, ""}, noOrdinal, "la_selva"},
+		{"no ordinal, no la selva", trackConfig{[]trackDefinition{other, {Key: "third"}}, ""}, noOrdinal, ""},
+	}
+	for _, test := range tests {
+		track, err := test.config.find(test.session)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%s: find = %s, want an error", test.name, track.Key)
+			}
+			continue
+		}
+		if err != nil || track.Key != test.want {
+			t.Errorf("%s: find = %s (%v), want %s", test.name, track.Key, err, test.want)
+		}
+	}
+}
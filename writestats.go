@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
@@ -74,6 +75,23 @@ func saveToken(path string, token *oauth2.Token) {
 	json.NewEncoder(f).Encode(token)
 }
 
+// Stat Builder stat line columns that hold speed interval times, filled in by each
+// interval's "column" (see intervals.json)
+var statLineIntervalColumns = []string{"S", "T", "U", "V", "W", "Z", "AA"}
+
+// The stat line columns for the intervals that depend on the car's class, filled
+// in order with the class's intervals that don't name a column
+var statLineClassColumns = []string{"U", "V", "W"}
+
+// Returns the position of a column (e.g. "AA") in a row starting at column A
+func columnIndex(column string) int {
+	index := 0
+	for _, c := range column {
+		index = index*26 + int(c-'A') + 1
+	}
+	return index - 1
+}
+
 // Check if flag was passed
 func isFlagPassed(name string) bool {
 	found := false
@@ -88,13 +106,172 @@ func isFlagPassed(name string) bool {
 func main() {
 	// Parse Flags
 	ordinalPTR := flag.Bool("o", false, "Enables Ordinal Info Collection Mode")
-	racePTR := flag.Bool("r", false, "Enables Race Mode for tracking best lap time, track top speed, and lap sector times (La Selva Circuit)")
+	racePTR := flag.Bool("r", false, "Enables Race Mode for tracking best lap time, track top speed, and lap sector times")
 	dragPTR := flag.Bool("d", false, "Enables Drag Mode to calculate Drag times and speeds")
+	brakingPTR := flag.Bool("b", false, "Enables Braking Mode to print braking distance, deceleration and wheel lock-up for every stop")
+	skidpadPTR := flag.Bool("k", false, "Enables Skidpad Mode to print the sustained lateral G at 60 and 120 mph")
+	dynoPTR := flag.String("dyno", "", "Enables Dyno Mode to write power and torque curves to this name with .csv and .svg added, e.g. dyno")
+	gearingPTR := flag.Bool("g", false, "Enables Gearing Mode to print the gear ratios, tire size and best shift points")
+	rollPTR := flag.Bool("roll", false, "Enables Roll Race Mode to time from a steady cruise to wide open throttle")
+	rollsPTR := flag.String("rolls", "40-140,60-130", "Comma separated list of roll races to time in Roll Race Mode, in mph (or add km/h, e.g. 100-200km/h)")
+	segmentPTR := flag.Bool("s", false, "Enables Segment Mode to print stats for every session and run in the log separately")
+	rewindPTR := flag.String("rewind", "splice", "How rewinds in the log are handled: splice (remove the rewound data) or split (split the log at each rewind)")
+	listenPTR := flag.Bool("l", false, "Enables Listen Mode to log Forza Data Out telemetry to log.csv")
+	evPTR := flag.Bool("e", false, "EV mode - keeps logging in menus while in Listen Mode (for collecting electric vehicle stats)")
+	portPTR := flag.Int("port", 9999, "UDP port to receive Forza Data Out on")
+	formatPTR := flag.String("format", "auto", "Data Out packet format: auto (detect from packets), fm7sled, fm7dash, fh (Forza Horizon 4/5) or fm8")
+	capturePTR := flag.String("capture", "", "Also saves every packet received in Listen Mode to this capture file, for use with -replay")
+	replayPTR := flag.String("replay", "", "Calculates stats from this capture file instead of log.csv")
+	emitPTR := flag.String("emit", "", "Sends log.csv (or the -replay capture) as Data Out packets to this address, e.g. 127.0.0.1:9999")
+	ratePTR := flag.Float64("rate", 1, "Playback speed for -emit (2 = twice as fast, 0.5 = half speed)")
+	forwardPTR := flag.String("forward", "", "Forwards every packet received on -port to this comma separated list of addresses, e.g. 127.0.0.1:5300,192.168.1.20:9999")
+	intervalsPTR := flag.String("intervals", "intervals.json", "JSON file listing the speed intervals to time for each car class (defaults to the stats spreadsheet's intervals if it doesn't exist)")
+	trackPTR := flag.String("track", "", "Track to use in Race Mode, by name or file name in -tracks (defaults to the log's TrackOrdinal, or La Selva Circuit)")
+	tracksPTR := flag.String("tracks", "tracks", "Directory of track definition files (*.json) for Race Mode")
+	lapsPTR := flag.String("laps", "", "Writes the lap table (every lap's time, sectors, speeds, fuel used and validity) to this .csv or .json file")
+	lapSheetPTR := flag.String("lapsheet", "", "Also writes the lap table to this sheet tab in Race Mode, e.g. Laps")
+	distancesPTR := flag.String("distances", "", "Comma separated list of extra distances to time in Drag Mode and Segment Mode, e.g. 100m,402m,1km")
+	smoothPTR := flag.Int("smooth", 0, "Smooths Speed with a moving average over this many data points before timing speed intervals (0 = off)")
 	flag.Parse()
 	ordinalMode := *ordinalPTR
 	raceMode := *racePTR
 	dragMode := *dragPTR
 
+	var format *PacketFormat // nil to detect the format from the packets
+	if *formatPTR != "auto" {
+		var err error
+		format, err = getPacketFormat(*formatPTR)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	// Listen Mode doesn't touch the spreadsheet, so it runs before any credentials are needed
+	if *listenPTR {
+		log.Println("Listen mode enabled")
+		check(listen(*portPTR, format, "log.csv", *capturePTR, *evPTR))
+		return
+	}
+
+	// Forward Mode shares the game's single Data Out stream between several tools
+	if *forwardPTR != "" {
+		log.Println("Forward mode enabled")
+		forward(*portPTR, strings.Split(*forwardPTR, ","))
+		return
+	}
+
+	// Emit Mode plays telemetry back over UDP for testing other tools, without touching the spreadsheet
+	if *emitPTR != "" {
+		log.Println("Emit mode enabled")
+		var packets []emitPacket
+		if *replayPTR != "" { // Captures are sent exactly as they were received
+			packets = captureDatagrams(*replayPTR)
+		} else {
+			session := readLog("log.csv", format)
+			if format == nil { // Send in the same format the log was recorded in
+				format = session.Format
+			}
+			packets = logDatagrams(session, format)
+		}
+		emit(*emitPTR, packets, *ratePTR)
+		return
+	}
+
+	// Load telemetry from log.csv, or decode it again from the original packets in a capture file
+	var rows *Session
+	if *replayPTR != "" {
+		rows = replayCapture(*replayPTR, format, *evPTR)
+	} else {
+		rows = readLog("log.csv", format) // -format overrides the game the log was detected as
+	}
+
+	// Unwrap timestamps and deal with rewinds before anything uses the times
+	parts, fixes := normalizeTime(rows, *rewindPTR)
+	for _, fix := range fixes {
+		fmt.Println(fix)
+	}
+	if len(parts) > 1 && !*segmentPTR {
+		rows = longestPart(parts)
+		fmt.Printf("Log was split into %d parts, using the longest (%d data points)\n", len(parts), rows.Len())
+	} else {
+		rows = parts[0]
+	}
+
+	intervals, err := loadIntervals(*intervalsPTR)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	timing := intervalTiming{Config: intervals, Smoothing: *smoothPTR}
+	distances, err := dragDistances(*distancesPTR)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	trackList, err := loadTracks(*tracksPTR)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	tracks := trackConfig{Tracks: trackList, Name: *trackPTR}
+
+	// The lap table is written before any mode runs, so it works alongside all of
+	// them (Race Mode writes it with the laps it times)
+	if *lapsPTR != "" && !raceMode {
+		track, err := tracks.find(rows)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		lapRows := rows
+		if *segmentPTR { // Segment Mode keeps every part, so time the laps of the longest
+			lapRows = longestPart(parts)
+		}
+		saveLaps(*lapsPTR, calcLaps(lastTrackData(lapRows), track), track)
+	}
+
+	// Segment Mode only prints results, so it doesn't need the spreadsheet either
+	if *segmentPTR {
+		log.Println("Segment mode enabled")
+		printSegments(parts, timing, distances, tracks)
+		return
+	}
+
+	// Braking Mode only prints results too
+	if *brakingPTR {
+		log.Println("Braking mode enabled")
+		printBraking(rows, timing)
+		return
+	}
+
+	// Skidpad Mode only prints results too
+	if *skidpadPTR {
+		log.Println("Skidpad mode enabled")
+		printSkidpad(rows)
+		return
+	}
+
+	// Dyno Mode only writes files and prints results
+	if *dynoPTR != "" {
+		log.Println("Dyno mode enabled")
+		printDyno(rows, *dynoPTR)
+		return
+	}
+
+	// Gearing Mode only prints results too
+	if *gearingPTR {
+		log.Println("Gearing mode enabled")
+		printGearing(rows)
+		return
+	}
+
+	// Roll Race Mode only prints results too
+	if *rollPTR {
+		log.Println("Roll race mode enabled")
+		races, err := parseRollRaces(*rollsPTR)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printRollRaces(rows, races)
+		return
+	}
+
 	if ordinalMode {
 		log.Println("Ordinal Info Collection mode enabled")
 	} else if raceMode {
@@ -140,10 +317,11 @@ func main() {
 		Aspiration   string
 		Litreage     string
 		Value        string
+		Weight       string // Official weight in lb, overrides the estimate from telemetry (optional)
 	}
 
 	ordinalMap := make(map[string]Car)
-	ordinalNumber, err := getOrdinalNumber("log.csv") // Get ordinal number of current car
+	ordinalNumber, err := getOrdinalNumber(rows) // Get ordinal number of current car
 	if err != nil {
 		log.Fatalf("Unable to retrieve Ordinal Number. CSV file is likely empty.")
 	}
@@ -164,7 +342,7 @@ func main() {
 			if len(row) < 15 { // If the information row is incomplete
 				continue
 			}
-			ordinalMap[fmt.Sprintf("%v", row[0])] = Car{
+			car := Car{
 				RaceTeam:     fmt.Sprintf("%v", row[1]),
 				Manufacturer: fmt.Sprintf("%v", row[2]),
 				Model:        fmt.Sprintf("%v", row[3]),
@@ -181,6 +359,10 @@ func main() {
 				Litreage:     fmt.Sprintf("%v", row[14]),
 				Value:        fmt.Sprintf("%v", row[15]),
 			}
+			if len(row) > 16 {
+				car.Weight = fmt.Sprintf("%v", row[16])
+			}
+			ordinalMap[fmt.Sprintf("%v", row[0])] = car
 		}
 		if isFlagPassed("o") == false {
 			if val, isPresent := ordinalMap[ordinalNumber]; isPresent { // Check if the ordinal Number is in the map
@@ -199,7 +381,7 @@ func main() {
 		ordinalSheetLength := len(ordinalMap)
 		writeRange = "Ordinal Data!A" + strconv.FormatInt(int64(ordinalSheetLength+1), 10)
 		rbValues := [][]interface{}{}
-		ordinalNums, err := getAllOrdinalNumbers("log.csv")
+		ordinalNums, err := getAllOrdinalNumbers(rows)
 		check(err)
 		for _, v := range ordinalNums {
 			wv := append(writeValues, v)
@@ -218,12 +400,22 @@ func main() {
 		}
 		fmt.Println("Successfully printed ordinal numbers to output sheet!")
 
-	} else if isFlagPassed("r") == true { // Enables Race Mode: writes Best Lap Time, Track Top Speed, Track Sector Times
+	} else if isFlagPassed("r") == true { // Enables Race Mode: writes Best Lap Time, Track Top Speed, the best valid lap's gap to the theoretical best, Track Sector Times
 		timeWriteRange := "Stat Builder!B8"
+		gapWriteRange := "Stat Builder!AE8" // Between the stat line and the sector times
 		speedWriteRange := "Stat Builder!Y8"
 		sectorsWriteRange := "Stat Builder!AF8"
-		bestLap, topSpeed, times := calcRaceStats("log.csv")
+		track, err := tracks.find(rows)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Printf("Track: %s\n", track.Name)
+		bestLap, gap, topSpeed, times, laps := calcRaceStats(rows, track)
+		if *lapsPTR != "" {
+			saveLaps(*lapsPTR, laps, track)
+		}
 		tWV := []interface{}{bestLap}
+		gWV := []interface{}{gap}
 		sWV := []interface{}{topSpeed}
 		secWV := []interface{}{}
 		for _, v := range times {
@@ -237,6 +429,12 @@ func main() {
 		if err != nil {
 			log.Fatalf("Unable to print data to sheet. %v", err)
 		}
+		var vr5 sheets.ValueRange
+		vr5.Values = append(vr5.Values, gWV) // Theoretical Best Gap
+		_, err = srv.Spreadsheets.Values.Update(spreadsheetId, gapWriteRange, &vr5).ValueInputOption("USER-ENTERED").Do()
+		if err != nil {
+			log.Fatalf("Unable to print data to sheet. %v", err)
+		}
 		var vr2 sheets.ValueRange
 		vr2.Values = append(vr2.Values, sWV) // Track Top Speed
 		_, err = srv.Spreadsheets.Values.Update(spreadsheetId, speedWriteRange, &vr2).ValueInputOption("USER-ENTERED").Do()
@@ -249,16 +447,39 @@ func main() {
 		if err != nil {
 			log.Fatalf("Unable to print data to sheet. %v", err)
 		}
+		if *lapSheetPTR != "" { // Lap Table, on its own tab, cleared first so no rows from a longer race are left over
+			_, err = srv.Spreadsheets.Values.Clear(spreadsheetId, *lapSheetPTR, &sheets.ClearValuesRequest{}).Do()
+			if err != nil {
+				log.Fatalf("Unable to clear the lap table sheet. %v", err)
+			}
+			var vr4 sheets.ValueRange
+			for _, row := range lapTable(laps, track) {
+				rowValues := []interface{}{}
+				for _, v := range row {
+					rowValues = append(rowValues, v)
+				}
+				vr4.Values = append(vr4.Values, rowValues)
+			}
+			_, err = srv.Spreadsheets.Values.Update(spreadsheetId, *lapSheetPTR+"!A1", &vr4).ValueInputOption("USER-ENTERED").Do()
+			if err != nil {
+				log.Fatalf("Unable to print lap table to sheet. %v", err)
+			}
+		}
 		fmt.Println("Successfully printed data to output sheet!")
 
-	} else if isFlagPassed("d") == true { // Enables Drag Mode: Prints Drag times and speeds
+	} else if isFlagPassed("d") == true { // Enables Drag Mode: Writes the best run's Drag times and speeds
 		timesWriteRange := "Stat Builder!AK8"
 		speedsWriteRange := "Stat Builder!AK9"
-		times, speeds := calcDragTimes("log.csv")
+		runs, best := printDragRuns(rows, distances)
+		if best < 0 {
+			log.Fatalf("No drag run reached %s, nothing to write.", dragSheetDistances[0])
+		}
+		times, speeds := runs[best].sheetValues()
 		tWV := []interface{}{}
 		for _, v := range times {
 			tWV = append(tWV, v)
 		}
+		tWV = append(tWV, len(runs)) // Run count, after the 1 mile time
 		sWV := []interface{}{}
 		for _, v := range speeds {
 			sWV = append(sWV, v)
@@ -281,39 +502,64 @@ func main() {
 
 	} else { // Write Stat Line Data to Stat Builder Sheet if no flags present
 		writeRange = "Stat Builder!A8"
-		statValues := calcstats("log.csv")
+		statValues := calcstats(rows, timing)
+		lateralGs := skidpadStats(rows) // at 60 and 120 mph
+
+		// Use the official weight when it's known, otherwise estimate it from the telemetry
+		weight, powerWeight := "", ""
+		mass := estimateMass(rows)
+		if lb, isKnown := parseWeight(currentCar.Weight); isKnown {
+			mass = massEstimate{Mass: lb / kgToLb, Found: true}
+			fmt.Printf("Weight: %.0f lb (from Ordinal Data)\n", lb)
+		} else if mass.Found {
+			fmt.Printf("Weight: %.0f ± %.0f lb (estimated from %d data points)\n", mass.Mass*kgToLb, mass.Band*kgToLb, mass.DataPoints)
+		}
+		if mass.Found {
+			weight = strconv.FormatFloat(mass.Mass*kgToLb, 'f', 0, 64)
+			if hp, err := strconv.ParseFloat(statValues.Get("Peak Power (hp)"), 64); err == nil {
+				powerWeight = strconv.FormatFloat(powerToWeight(hp, mass.Mass), 'f', 0, 64)
+			}
+		}
 		carFullName := currentCar.Number + " " + currentCar.Manufacturer + " " + currentCar.Model
+		// Interval columns are left blank when they aren't timed for the car's class (see intervals.json)
 		writeValues = append(writeValues, // Builds Stat Line to leaderboard specifications
-			carFullName,            // Car Name
-			"",                     // Best Lap Time (not handled)
-			currentCar.Year,        // Year
-			currentCar.Country,     // Country
-			"",                     // Country Flag (not handled)
-			statValues[0],          // PI Class Number
-			currentCar.Designation, // Car Designation
-			currentCar.TypeClass,   // Car Type (Category)
-			statValues[1],          // Drivetrain (from actual stats, not default data)
-			currentCar.Setup,       // Engine Setup
-			currentCar.Litreage,    // Engine Litreage
-			currentCar.Engine,      // Engine
-			currentCar.Aspiration,  // Aspiration
-			statValues[12],         // Peak Boost
-			statValues[2],          // Peak Horsepower
-			statValues[3],          // Peak Torque
-			"",                     // Weight (not handled)
-			"",                     // Power to Weight (not handled)
-			statValues[4],          // 0-60 Time
-			statValues[5],          // 0-100 Time
-			statValues[6],          // CUSTOMIZED FORMAT TO REVERT LATER! UPDATE BELOW VALUES******** 50-100 Time
-			statValues[7],          // 60-150 Time
-			statValues[8],          // 100-200 Time
-			statValues[11],         // Top Speed
-			"",                     // Track Top Speed (not handled)
-			statValues[9],          // 60-0 Time
-			statValues[10],         // 100-0 Time
-			"",                     // Lateral Gs at 60mph (not handled)
-			"",                     // Lateral Gs at 120mpg (not handled)
-			currentCar.Value)       // Car Value
+			carFullName,                           // Car Name
+			"",                                    // Best Lap Time (not handled)
+			currentCar.Year,                       // Year
+			currentCar.Country,                    // Country
+			"",                                    // Country Flag (not handled)
+			statValues.Get("PI"),                  // PI Class Number
+			currentCar.Designation,                // Car Designation
+			currentCar.TypeClass,                  // Car Type (Category)
+			statValues.Get("Drivetrain"),          // Drivetrain (from actual stats, not default data)
+			currentCar.Setup,                      // Engine Setup
+			currentCar.Litreage,                   // Engine Litreage
+			currentCar.Engine,                     // Engine
+			currentCar.Aspiration,                 // Aspiration
+			statValues.Get("Peak Boost"),          // Peak Boost
+			statValues.Get("Peak Power (hp)"),     // Peak Horsepower
+			statValues.Get("Peak Torque (ft-lb)"), // Peak Torque
+			weight,                                // Weight (lb)
+			powerWeight,                           // Power to Weight (hp/tonne)
+			"",                                    // 0-60 Time (interval column S)
+			"",                                    // 0-100 Time (interval column T)
+			"",                                    // Class interval 1 (interval column U)
+			"",                                    // Class interval 2 (interval column V)
+			"",                                    // Class interval 3 (interval column W)
+			statValues.Get("Top Speed (mph)"),     // Top Speed
+			"",                                    // Track Top Speed (not handled)
+			"",                                    // 60-0 Time (interval column Z)
+			"",                                    // 100-0 Time (interval column AA)
+			lateralGs[0],                          // Lateral Gs at 60mph
+			lateralGs[1],                          // Lateral Gs at 120mph
+			currentCar.Value)                      // Car Value
+
+		// Each interval timed for the car's class goes in its column
+		for _, interval := range timing.Config.forClass(rows.Format, statValues.Get("Class")) {
+			if interval.Column != "" {
+				writeValues[columnIndex(interval.Column)] = statValues.Get(interval.Name)
+			}
+		}
 
 		// Write Data to Sheet
 		var vr sheets.ValueRange
@@ -324,6 +570,21 @@ func main() {
 		}
 		fmt.Println("Successfully printed data to output sheet!")
 
+		// Times within each other's uncertainty are a tie on the leaderboard
+		fmt.Printf("Class %s intervals:\n", statValues.Get("Class"))
+		for _, name := range statValues.Names {
+			if _, isTimed := statValues.Uncertainties[name]; !isTimed {
+				continue
+			}
+			note := " (no column on the stat line)"
+			for _, interval := range timing.Config.forClass(rows.Format, statValues.Get("Class")) {
+				if interval.Name == name && interval.Column != "" {
+					note = " (column " + interval.Column + ")"
+				}
+			}
+			fmt.Printf("  %s: %s s%s\n", name, statValues.Format(name), note)
+		}
+
 		// Trigger Apps Script to set data colors
 		service, err := script.NewService(ctx, option.WithHTTPClient(client))
 		if err != nil {